@@ -72,6 +72,7 @@ export class GameHandler {
 
     }
     handleNewQuestion(msg) {
+        this.questionId = msg.id;
         const question = {
             id: msg.id,
             question: msg.question,
@@ -123,6 +124,7 @@ export class GameHandler {
         const msg = {
             action: "player_answer",
             room: this.roomId,
+            questionId: this.questionId,
             answer: answerId,
             answerTime: answerTime,
         };
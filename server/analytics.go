@@ -0,0 +1,205 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+const (
+	DifficultyUnrated = "unrated"
+	DifficultyEasy    = "easy"
+	DifficultyMedium  = "medium"
+	DifficultyHard    = "hard"
+)
+
+const (
+	// Questions need this many answers before they get a difficulty
+	minAnswersForDifficulty = 10
+	easyCorrectRate         = 0.8
+	hardCorrectRate         = 0.4
+	// A question is suspect when a wrong option is the most popular one and
+	// fewer than this share of players get it right, usually a wrong answer key
+	suspectCorrectRate = 0.25
+)
+
+// QuestionStats aggregates every answer given to one question
+type QuestionStats struct {
+	QuestionID   int            `json:"questionId"`
+	Question     string         `json:"question"`
+	TimesAsked   int            `json:"timesAsked"`
+	Answers      int            `json:"answers"`
+	Unanswered   int            `json:"unanswered"`
+	Correct      int            `json:"correct"`
+	TotalTimeMs  int64          `json:"-"`
+	OptionCounts map[string]int `json:"optionCounts"` // quiz.json option ID -> times picked
+
+	CorrectRate   float64 `json:"correctRate"` // Of answers given, unanswered not counted
+	AverageTimeMs int64   `json:"averageTimeMs"`
+	Difficulty    string  `json:"difficulty"`
+	Suspect       bool    `json:"suspect"`
+}
+
+// questionStats is keyed by question ID and guarded by questionMutex
+var questionStats = make(map[int]*QuestionStats)
+
+func statsFor(q *Question) *QuestionStats {
+	stats := questionStats[q.ID]
+	if stats == nil {
+		stats = &QuestionStats{
+			QuestionID:   q.ID,
+			Question:     q.Text,
+			OptionCounts: make(map[string]int),
+			Difficulty:   DifficultyUnrated,
+		}
+		questionStats[q.ID] = stats
+	}
+	return stats
+}
+
+// addAnswer counts one player's final answer, optionID is empty if they
+// didn't answer in time
+func (s *QuestionStats) addAnswer(optionID string, correct bool, timeMs int64) {
+	if optionID == "" {
+		s.Unanswered++
+		return
+	}
+	s.Answers++
+	s.OptionCounts[optionID]++
+	s.TotalTimeMs += timeMs
+	if correct {
+		s.Correct++
+	}
+}
+
+// update recomputes the derived fields after answers were added
+func (s *QuestionStats) update(q *Question) {
+	if s.Answers == 0 {
+		return
+	}
+	s.CorrectRate = float64(s.Correct) / float64(s.Answers)
+	s.AverageTimeMs = s.TotalTimeMs / int64(s.Answers)
+
+	if s.Answers < minAnswersForDifficulty {
+		s.Difficulty = DifficultyUnrated
+		s.Suspect = false
+		return
+	}
+	switch {
+	case s.CorrectRate >= easyCorrectRate:
+		s.Difficulty = DifficultyEasy
+	case s.CorrectRate <= hardCorrectRate:
+		s.Difficulty = DifficultyHard
+	default:
+		s.Difficulty = DifficultyMedium
+	}
+
+	popular := ""
+	for id, count := range s.OptionCounts {
+		if count > s.OptionCounts[popular] || (count == s.OptionCounts[popular] && id < popular) {
+			popular = id
+		}
+	}
+	s.Suspect = popular != q.Answer && s.CorrectRate < suspectCorrectRate
+}
+
+// recordQuestionStats adds a finished round's answers to the question's
+// stats. Answers can change until the round ends, so they're counted here
+// rather than as they come in.
+func recordQuestionStats(q *Question, answers []*PlayerAnswer) {
+	questionMutex.Lock()
+	defer questionMutex.Unlock()
+
+	stats := statsFor(q)
+	stats.TimesAsked++
+	for _, pa := range answers {
+		stats.addAnswer(pa.Answer, pa.Correct, pa.AnswerTime)
+	}
+	stats.update(q)
+}
+
+// loadQuestionStats rebuilds the stats from stored match history
+func loadQuestionStats() error {
+	if store == nil {
+		return nil
+	}
+	matches, err := store.Matches(time.Time{})
+	if err != nil {
+		return err
+	}
+
+	questionMutex.Lock()
+	defer questionMutex.Unlock()
+
+	byID := make(map[int]*Question)
+	for i := range questions {
+		byID[questions[i].ID] = &questions[i]
+	}
+	for _, match := range matches {
+		for _, round := range match.RoundLog {
+			q := byID[round.QuestionID]
+			if q == nil {
+				continue // Removed from quiz.json since
+			}
+			stats := statsFor(q)
+			stats.TimesAsked++
+			for _, a := range round.Answers {
+				stats.addAnswer(a.OptionID, a.Correct, a.TimeMs)
+			}
+		}
+	}
+	for id, stats := range questionStats {
+		stats.update(byID[id])
+	}
+	slog.Info("Loaded question stats", "questions", len(questionStats), "matches", len(matches))
+	return nil
+}
+
+// targetDifficulty is the difficulty a room's next question should have.
+// Games start easy and get harder, sudden death is always hard.
+func (room *Room) targetDifficulty() string {
+	round := room.Round + 1
+	switch {
+	case room.SuddenDeathRounds > 0 || round > 6:
+		return DifficultyHard
+	case round > 3:
+		return DifficultyMedium
+	default:
+		return DifficultyEasy
+	}
+}
+
+// difficultyOf returns the question's difficulty and whether it's suspect.
+// Call with questionMutex held.
+func difficultyOf(q *Question) (string, bool) {
+	stats := questionStats[q.ID]
+	if stats == nil {
+		return DifficultyUnrated, false
+	}
+	return stats.Difficulty, stats.Suspect
+}
+
+// questionReport returns a copy of every question's stats, in quiz.json order
+func questionReport() []QuestionStats {
+	questionMutex.Lock()
+	defer questionMutex.Unlock()
+
+	report := []QuestionStats{}
+	for i := range questions {
+		q := &questions[i]
+		stats := QuestionStats{
+			QuestionID:   q.ID,
+			Question:     q.Text,
+			OptionCounts: map[string]int{},
+			Difficulty:   DifficultyUnrated,
+		}
+		if s := questionStats[q.ID]; s != nil {
+			stats = *s
+			stats.OptionCounts = make(map[string]int)
+			for id, count := range s.OptionCounts {
+				stats.OptionCounts[id] = count
+			}
+		}
+		report = append(report, stats)
+	}
+	return report
+}
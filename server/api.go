@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func writeJSONResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Error writing HTTP response", "err", err)
+	}
+}
+
+// handleListRoomsHTTP serves the public room browser at GET /api/rooms
+func handleListRoomsHTTP(w http.ResponseWriter, r *http.Request) {
+	clientsMutex.Lock()
+	list := listPublicRooms()
+	clientsMutex.Unlock()
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"rooms": list,
+	})
+}
+
+// handleGetProfileHTTP serves a player's profile and lifetime stats at
+// GET /api/profiles/{id}
+func handleGetProfileHTTP(w http.ResponseWriter, r *http.Request) {
+	if profiles == nil {
+		writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "Profiles are disabled on this server"})
+		return
+	}
+	id := mux.Vars(r)["id"]
+	profile, stats, err := loadProfile(id)
+	if err == errProfileNotFound {
+		writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "Profile not found"})
+		return
+	}
+	if err != nil {
+		slog.Error("Error loading profile", "profile", id, "err", err)
+		writeJSONResponse(w, http.StatusInternalServerError, map[string]string{"error": "Could not load profile"})
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"profile": profile,
+		"stats":   stats,
+	})
+}
+
+// handleLeaderboardHTTP serves GET /api/leaderboards/{board}, where board is
+// wins, rating, fastest or streak. Query parameters are period (all or week),
+// page (from 1) and pageSize.
+func handleLeaderboardHTTP(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "Match history is disabled on this server"})
+		return
+	}
+	board := mux.Vars(r)["board"]
+	if !slices.Contains(leaderboards, board) {
+		writeJSONResponse(w, http.StatusNotFound, map[string]interface{}{
+			"error":        "Unknown leaderboard",
+			"leaderboards": leaderboards,
+		})
+		return
+	}
+	query := r.URL.Query()
+	period := query.Get("period")
+	if period == "" {
+		period = PeriodAll
+	}
+	if period != PeriodAll && period != PeriodWeek {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": "period must be all or week"})
+		return
+	}
+	page, err := queryInt(query.Get("page"), 1)
+	if err != nil || page < 1 {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": "page must be a positive number"})
+		return
+	}
+	pageSize, err := queryInt(query.Get("pageSize"), defaultPageSize)
+	if err != nil || pageSize < 1 || pageSize > maxPageSize {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("pageSize must be between 1 and %d", maxPageSize)})
+		return
+	}
+
+	matches, err := store.Matches(periodStart(period))
+	if err != nil {
+		slog.Error("Error loading matches for leaderboard", "err", err)
+		writeJSONResponse(w, http.StatusInternalServerError, map[string]string{"error": "Could not load match history"})
+		return
+	}
+	entries := computeLeaderboard(board, matches, func(id string) bool {
+		_, err := profiles.Profile(id)
+		return err == nil
+	})
+
+	total := len(entries)
+	start := min((page-1)*pageSize, total)
+	end := min(start+pageSize, total)
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"board":    board,
+		"period":   period,
+		"page":     page,
+		"pageSize": pageSize,
+		"total":    total,
+		"entries":  entries[start:end],
+	})
+}
+
+// queryInt parses a query parameter, returning def if it's missing
+func queryInt(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// handleGetReplayHTTP serves a game's event log as JSON Lines at
+// GET /api/replays/{gameId}
+func handleGetReplayHTTP(w http.ResponseWriter, r *http.Request) {
+	gameID := mux.Vars(r)["gameId"]
+	clientsMutex.Lock()
+	playing := replayInProgress(gameID)
+	clientsMutex.Unlock()
+	if playing {
+		writeJSONResponse(w, http.StatusConflict, map[string]string{"error": "That game is still being played"})
+		return
+	}
+	path, err := replayPath(gameID)
+	if err != nil {
+		writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "Replay not found"})
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "Replay not found"})
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if _, err := io.Copy(w, file); err != nil {
+		slog.Warn("Error sending replay", "file", path, "err", err)
+	}
+}
+
+// handleQuestionStatsHTTP serves answer stats and the difficulty of every
+// question at GET /api/questions/stats. ?difficulty= and ?suspect=true filter
+// the list.
+func handleQuestionStatsHTTP(w http.ResponseWriter, r *http.Request) {
+	difficulty := r.URL.Query().Get("difficulty")
+	suspectOnly := r.URL.Query().Get("suspect") == "true"
+
+	report := []QuestionStats{}
+	for _, stats := range questionReport() {
+		if difficulty != "" && stats.Difficulty != difficulty {
+			continue
+		}
+		if suspectOnly && !stats.Suspect {
+			continue
+		}
+		report = append(report, stats)
+	}
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"questions": report,
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	matchesBucket  = []byte("matches")
+	profilesBucket = []byte("profiles")
+	tokensBucket   = []byte("tokens")
+)
+
+// boltStore is a MatchStore and ProfileStore in a single BoltDB file.
+// Matches and profiles are stored as JSON keyed by their ID, tokens map to
+// profile IDs.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{matchesBucket, profilesBucket, tokensBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) SaveMatch(match *MatchRecord) error {
+	data, err := json.Marshal(match)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(matchesBucket).Put([]byte(match.GameID), data)
+	})
+}
+
+func (s *boltStore) Match(gameID string) (*MatchRecord, error) {
+	var match *MatchRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(matchesBucket).Get([]byte(gameID))
+		if data == nil {
+			return errMatchNotFound
+		}
+		return json.Unmarshal(data, &match)
+	})
+	return match, err
+}
+
+func (s *boltStore) Matches(since time.Time) ([]*MatchRecord, error) {
+	matches := []*MatchRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(matchesBucket).ForEach(func(_, data []byte) error {
+			var match MatchRecord
+			if err := json.Unmarshal(data, &match); err != nil {
+				return err
+			}
+			if !match.EndedAt.IsZero() && !match.EndedAt.Before(since) {
+				matches = append(matches, &match)
+			}
+			return nil
+		})
+	})
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].EndedAt.Before(matches[j].EndedAt)
+	})
+	return matches, err
+}
+
+func (s *boltStore) Unfinished() ([]*MatchRecord, error) {
+	matches := []*MatchRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(matchesBucket).ForEach(func(_, data []byte) error {
+			var match MatchRecord
+			if err := json.Unmarshal(data, &match); err != nil {
+				return err
+			}
+			if match.EndedAt.IsZero() {
+				matches = append(matches, &match)
+			}
+			return nil
+		})
+	})
+	return matches, err
+}
+
+func (s *boltStore) SaveProfile(token string, profile *Profile) error {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(tokensBucket).Put([]byte(token), []byte(profile.ID)); err != nil {
+			return err
+		}
+		return tx.Bucket(profilesBucket).Put([]byte(profile.ID), data)
+	})
+}
+
+func (s *boltStore) Profile(id string) (*Profile, error) {
+	var profile *Profile
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(profilesBucket).Get([]byte(id))
+		if data == nil {
+			return errProfileNotFound
+		}
+		return json.Unmarshal(data, &profile)
+	})
+	return profile, err
+}
+
+func (s *boltStore) ProfileByToken(token string) (*Profile, error) {
+	var id string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(tokensBucket).Get([]byte(token))
+		if data == nil {
+			return errProfileNotFound
+		}
+		id = string(data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.Profile(id)
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
@@ -2,10 +2,11 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"math/rand"
 	"os"
 	"slices"
+	"strings"
 	"time"
 )
 
@@ -23,42 +24,46 @@ func LoadQuestions(filename string) error {
 	}
 
 	rand.Seed(time.Now().UnixNano())
-	log.Printf("Loaded %d questions from %s\n", len(questions), filename)
+	slog.Info("Loaded questions", "count", len(questions), "file", filename)
 	return nil
 }
 
-// GetRandomQuestion returns a random question from the pool
-func GetRandomQuestion() *Question {
+// NextQuestion draws the next question from the room's deck, reshuffling
+// the whole pool once every question has been asked. It prefers questions
+// of the difficulty the game is at and skips ones that look broken.
+func (room *Room) NextQuestion() *Question {
 	questionMutex.Lock()
 	defer questionMutex.Unlock()
 
 	if len(questions) == 0 {
 		return nil
 	}
-	return &questions[rand.Intn(len(questions))]
-}
-
-func GenerateInitialSabotageList() []*Sabotage {
-	var initialSabotage = []string{
-		"BugSwarm",
-		"BugEat",
-		"BugLamp",
-		"FakePopup",
-		"CodeRain",
-		"Flicker",
-		// "GlitchText",
-		"BackwardText",
-		"Blurry",
-		"MouseDrift",
+	if len(room.QuestionDeck) == 0 {
+		room.QuestionDeck = rand.Perm(len(questions))
 	}
-	sabotages := make([]*Sabotage, len(initialSabotage))
-	for i, name := range initialSabotage {
-		sabotages[i] = &Sabotage{
-			Name: name,
-			Used: false,
+
+	// Unrated questions fit anywhere until enough players have answered them
+	target := room.targetDifficulty()
+	pick, fallback := -1, -1
+	for i, idx := range room.QuestionDeck {
+		difficulty, suspect := difficultyOf(&questions[idx])
+		if suspect {
+			continue
+		}
+		if fallback == -1 {
+			fallback = i
+		}
+		if difficulty == target || difficulty == DifficultyUnrated {
+			pick = i
+			break
 		}
 	}
-	return sabotages
+	if pick == -1 {
+		pick = max(fallback, 0)
+	}
+	next := room.QuestionDeck[pick]
+	room.QuestionDeck = slices.Delete(room.QuestionDeck, pick, pick+1)
+	return &questions[next]
 }
 
 func (room *Room) EvaluateRoundResults() *RoundResult {
@@ -100,165 +105,173 @@ func (room *Room) EvaluateRoundResults() *RoundResult {
 	}
 }
 func (room *Room) CalculateHealth(winner *PlayerAnswer, losers []*PlayerAnswer) {
-	// if winner != nil && winner.Client != nil {
-	// 	client := winner.Client
-	// 	if client.Health < 5 {
-	// 		client.Health++
-	// 		log.Printf("Player %s gains 1 health. Total: %d", client.ID, client.Health)
-	// 	}
-	// }
+	// Healing is one of the rewards the winner can pick, see OfferRewards
+	damage := 1
+	if winner != nil && winner.Client != nil && room.DoubleDamage[winner.Client.ID] {
+		damage = 2
+	}
 
 	for _, client := range losers {
 		if client == nil || client.Client == nil {
 			continue
 		}
 		c := client.Client
-		c.Health--
+		c.Health -= damage
 
 		if c.Health <= 0 {
 			c.Health = 0
 		}
-		log.Printf("Player %s loses 1 health. Remaining: %d", c.ID, c.Health)
-	}
-	players := []map[string]interface{}{}
-	for _, c := range room.Players {
-		players = append(players, map[string]interface{}{
-			"id":     c.ID,
-			"name":   c.Name,
-			"health": c.Health,
-		})
-	}
-	for _, c := range room.Players {
-
-			c.ConnMutex.Lock()
-			err := c.Conn.WriteJSON(map[string]interface{}{
-				"type":    "player_update",
-				"players": players,
+		room.logHealth(c, -damage, "lost_round")
+		room.Log().Debug("Player lost health", "client", c.ID, "damage", damage, "health", c.Health)
+
+		// Eliminated players keep watching the game as spectators
+		if c.Health == 0 && !c.IsSpectator {
+			c.IsSpectator = true
+			c.EliminatedRound = room.Round
+			c.Send(map[string]interface{}{
+				"type":   "spectating",
+				"reason": "eliminated",
 			})
-			c.ConnMutex.Unlock()
-			if err != nil {
-				log.Printf("Error sending player_update to %s: %v", c.ID, err)
-			}
+		}
 	}
-	go func() {
-		time.Sleep(3 * time.Second)
-		room.CheckGameOver()
-	}()
+	room.Broadcast(map[string]interface{}{
+		"type":    "player_update",
+		"players": room.PlayerInfo(),
+	})
 }
 
 func (room *Room) AssignSabotagesToLosers(result *RoundResult) {
-    if result == nil || len(result.Losers) == 0 {
-        log.Println("No losers to assign sabotages to")
-        room.StartQuestion() // Continue game if no sabotages to assign
-        return
-    }
-
-    // Validate winner exists for manual sabotage selection
-    if result.Winner != nil && result.Winner.Client != nil {
-        validLosers := []*PlayerAnswer{}
-        
-        // Filter out invalid losers
-        for _, loser := range result.Losers {
-            if loser != nil && loser.Client != nil {
-                validLosers = append(validLosers, loser)
-            }
-        }
-
-        if len(validLosers) == 0 {
-            log.Println("No valid losers after filtering")
-            room.StartQuestion()
-            return
-        }
-
-        // Continue with valid losers
-        result.Losers = validLosers
-        
-
-		if result.Losers != nil {
-			for _, loser := range result.Losers {
-				if loser == nil || loser.Client == nil {
-					continue
-				}
-				loser.Client.Conn.WriteJSON(map[string]interface{}{
-					"type":   "wait_winner",
-					"winner": result.Winner.Client.Name,
-				})
-			}
-		}
-		// Collect all available sabotages for each loser
-		availablePerLoser := make(map[string]map[string]bool)
-		for _, loser := range result.Losers {
-			if loser == nil || loser.Client == nil {
-				continue
-			}
-			sabotageSet := make(map[string]bool)
-			for _, s := range room.AvailableSabotages[loser.Client.ID] {
-				if !s.Used {
-					sabotageSet[s.Name] = true
-				}
-			}
-			availablePerLoser[loser.Client.ID] = sabotageSet
-		}
+	if result == nil || len(result.Losers) == 0 {
+		room.Log().Debug("No losers to assign sabotages to")
+		room.StartQuestion() // Continue game if no sabotages to assign
+		return
+	}
 
-		// Calculate intersection of all sabotage names
-		var intersection []string
-		for name := range availablePerLoser[result.Losers[0].Client.ID] {
-			isCommon := true
-			for _, sset := range availablePerLoser {
-				if !sset[name] {
-					isCommon = false
-					break
-				}
-			}
-			if isCommon {
-				intersection = append(intersection, name)
-			}
+	// Filter out invalid losers and anyone eliminated this round
+	validLosers := []*PlayerAnswer{}
+	for _, loser := range result.Losers {
+		if loser != nil && loser.Client != nil && !loser.Client.IsSpectator {
+			validLosers = append(validLosers, loser)
 		}
+	}
+	if len(validLosers) == 0 {
+		room.Log().Debug("No valid losers after filtering")
+		room.StartQuestion()
+		return
+	}
+	result.Losers = validLosers
 
-		if len(intersection) == 0 {
-			log.Println("No common sabotage. Prompting winner to pick per-loser sabotages is not implemented.")
-			RandomSabotage(result.Losers, room)
-			return
-		}
+	// No winner: assign sabotages randomly
+	if result.Winner == nil || result.Winner.Client == nil {
+		RandomSabotage(result.Losers, room)
+		return
+	}
 
-		// Build a map where each loser maps to the same intersected sabotage list
-		sabotageChoices := make(map[string][]string)
-		for _, loser := range result.Losers {
-			if loser == nil || loser.Client == nil {
-				continue
-			}
-			sabotageChoices[loser.Client.ID] = intersection
-		}
+	room.OfferRewards(result)
+}
 
-		// Store sabotage selection state
-		room.SabotageSelection = &SabotageSelection{
-			WinnerID: result.Winner.Client.ID,
-			Choices:  sabotageChoices,
-			Pending:  make(map[string]bool),
+// waitForWinner tells the round's losers the winner is choosing until deadline
+func (room *Room) waitForWinner(result *RoundResult, deadline time.Time) {
+	for _, loser := range result.Losers {
+		loser.Client.Send(map[string]interface{}{
+			"type":     "wait_winner",
+			"winner":   result.Winner.Client.Name,
+			"timeLeft": int(time.Until(deadline).Seconds()),
+			"deadline": deadline.UnixMilli(),
+		})
+	}
+}
+
+// OfferSabotages lets the round winner pick a sabotage for each loser by
+// deadline, which is shared with the reward choice that may come before it
+func (room *Room) OfferSabotages(result *RoundResult, deadline time.Time) {
+	timeout := time.Until(deadline)
+
+	// Offer the winner each loser's own remaining sabotages
+	sabotageChoices := make(map[string][]string)
+	targets := []map[string]string{}
+	for _, loser := range result.Losers {
+		choices := []string{}
+		for _, s := range room.availableSabotages(loser.Client.ID) {
+			choices = append(choices, s.Name)
 		}
-		for _, loser := range result.Losers {
-			if loser == nil || loser.Client == nil {
-				continue
-			}
-			room.SabotageSelection.Pending[loser.Client.ID] = true
+		if len(choices) == 0 {
+			continue
 		}
-
-		//Notify winner
-		result.Winner.Client.ConnMutex.Lock()
-		err := result.Winner.Client.Conn.WriteJSON(map[string]interface{}{
-			"type":    "choose_sabotage",
-			"choices": sabotageChoices,
+		sabotageChoices[loser.Client.ID] = choices
+		targets = append(targets, map[string]string{
+			"id":   loser.Client.ID,
+			"name": loser.Client.Name,
 		})
-		result.Winner.Client.ConnMutex.Unlock()
-		if err != nil {
-			log.Printf("error sending sabotage choices to winner: %v", err)
-		}
+	}
+	if len(sabotageChoices) == 0 {
+		room.Log().Debug("Losers have no sabotages left")
+		room.StartQuestion()
+		return
+	}
 
-	} else {
-		// No winner: assign sabotages randomly
-		RandomSabotage(result.Losers, room)
+	// Store sabotage selection state
+	selection := &SabotageSelection{
+		WinnerID: result.Winner.Client.ID,
+		Choices:  sabotageChoices,
+		Pending:  make(map[string]bool),
+		Deadline: deadline,
+	}
+	for targetID := range sabotageChoices {
+		selection.Pending[targetID] = true
+	}
+	room.SabotageSelection = selection
+
+	//Notify winner
+	err := result.Winner.Client.Send(map[string]interface{}{
+		"type":     "choose_sabotage",
+		"choices":  sabotageChoices,
+		"targets":  targets,
+		"timeLeft": int(timeout.Seconds()),
+		"deadline": deadline.UnixMilli(),
+	})
+	if err != nil {
+		room.Log().Warn("Error sending sabotage choices to winner", "err", err)
 	}
 
+	// Targets the winner hasn't picked for by the deadline get a random sabotage
+	room.Schedule(timeout, func() {
+		room.ResolvePendingSabotages(selection)
+	})
+}
+
+// ResolvePendingSabotages gives every target still pending in selection a
+// random sabotage and moves on to the next question
+func (room *Room) ResolvePendingSabotages(selection *SabotageSelection) {
+	if room.SabotageSelection != selection {
+		return // Already resolved by the winner
+	}
+	room.SabotageSelection = nil
+
+	losers := []*PlayerAnswer{}
+	for _, c := range room.ActivePlayers() {
+		if selection.Pending[c.ID] {
+			losers = append(losers, &PlayerAnswer{Client: c})
+		}
+	}
+	room.Log().Info("Sabotage selection timed out", "pending", len(losers))
+	for _, c := range room.Players {
+		if c.ID == selection.WinnerID {
+			c.Send(map[string]interface{}{
+				"type":    "sabotage_timeout",
+				"message": "Time's up! Remaining sabotages were picked at random.",
+			})
+		}
+	}
+	RandomSabotage(losers, room)
+}
+
+// SabotageTimeout is how long the round winner has to pick sabotages
+func (room *Room) SabotageTimeout() time.Duration {
+	if room.Settings.SabotageTimeout > 0 {
+		return time.Duration(room.Settings.SabotageTimeout) * time.Second
+	}
+	return sabotageSelectionTimeout
 }
 
 func RandomSabotage(losers []*PlayerAnswer, room *Room) {
@@ -266,131 +279,637 @@ func RandomSabotage(losers []*PlayerAnswer, room *Room) {
 		if loser == nil || loser.Client == nil {
 			continue
 		}
-		var available []*Sabotage
-		for _, s := range room.AvailableSabotages[loser.Client.ID] {
-			if !s.Used {
-				available = append(available, s)
-			}
-		}
+		available := room.availableSabotages(loser.Client.ID)
 		if len(available) == 0 {
 			continue
 		}
 
-		// Randomly choose one sabotage
-		chosenIdx := rand.Intn(len(available))
-		chosen := available[chosenIdx]
-
-		// Move to PlayerEffects and remove from AvailableSabotages
-		room.PlayerEffects[loser.Client.ID] = append(room.PlayerEffects[loser.Client.ID], chosen)
-		room.AvailableSabotages[loser.Client.ID] = slices.Delete(
-			room.AvailableSabotages[loser.Client.ID], chosenIdx,
-			chosenIdx+1,
-		)
-
-		log.Printf("RANDOM player effect: %+v", room.PlayerEffects[loser.Client.ID])
-		log.Printf("available sabotages: %+v", room.AvailableSabotages[loser.Client.ID])
-		// Update metadata
-		chosen.Used = true
-		chosen.UsedByID = "system"
-		chosen.TargetID = loser.Client.ID
-
-		// loser.Client.ConnMutex.Lock()
-		for _, c := range room.Players {
-			c.Conn.WriteJSON(map[string]interface{}{
-				"type":     "sabotage_applied",
-				"sabotage": chosen.Name,
-				"usedBy":   "System",
-				"targets":  loser.Client.Name,
-			})
+		// Randomly choose one sabotage, weighted by rarity
+		chosen := pickWeightedSabotage(available)
+
+		landed := room.applySabotage(loser.Client, chosen.Name, nil)
+
+		if !landed {
+			continue
 		}
-		// loser.Client.ConnMutex.Unlock()
+
+		room.Broadcast(map[string]interface{}{
+			"type":     "sabotage_applied",
+			"sabotage": chosen.Name,
+			"usedBy":   "System",
+			"targets":  loser.Client.Name,
+		})
 	}
 
-	go func() {
-		time.Sleep(3 * time.Second)
-		room.StartQuestion()
-	}()
+	room.Schedule(3*time.Second, room.StartQuestion)
 }
 
 func (room *Room) StartQuestion() {
 
-	question := GetRandomQuestion()
+	question := room.NextQuestion()
 	if question == nil {
-		log.Println("No question returned")
+		room.Log().Error("No question returned")
 		return
 	}
 
 	// Reset previous round's answers, effects, etc.
+	room.Round++
 	room.Question = question
 	room.QuestionStart = time.Now().UnixMilli()
 	room.AnswerLog = []*PlayerAnswer{}
 	room.SabotageSelection = nil
+	room.RewardSelection = nil
+	room.DoubleDamage = make(map[string]bool)
+	room.TimeBonus = make(map[string]time.Duration)
+	room.AnswerLocks = make(map[string]time.Time)
+	room.OptionIDs = make(map[string]map[string]string)
 
 	// Collect sabotage effects per player
 	playerEffects := make(map[string][]string)
+	activeEffects := make(map[string][]ActiveEffect)
 	for _, player := range room.Players {
 		effects := []string{}
-		for _, sabotage := range room.PlayerEffects[player.ID] {
-			if sabotage.Used && sabotage.TargetID == player.ID {
-				effects = append(effects, sabotage.Name)
-			}
+		active := room.ActiveEffects(player.ID)
+		for _, effect := range active {
+			effects = append(effects, effect.ID)
 		}
 		playerEffects[player.ID] = effects
+		activeEffects[player.ID] = active
 	}
 
+	// Broadcast question to all players with their effects. Enforced
+	// effects change what each player gets and when.
+	round := room.Round
+	for _, player := range room.ActivePlayers() {
+		room.Log().Debug("Sending question", "client", player.ID, "effects", playerEffects[player.ID])
+
+		enforced := room.EnforcedEffects(player.ID)
+		options, optionIDs := shuffleOptions(question.Options)
+		room.OptionIDs[player.ID] = optionIDs
+		if n := int(enforced[EnforceHideOptions]); n > 0 {
+			options = hideOptions(options, n)
+		}
+		if v := enforced[EnforceTimeCrunch]; v > 0 {
+			crunch := time.Duration(v * float64(timeCrunchPerIntensity))
+			room.TimeBonus[player.ID] -= min(crunch, questionTimeout-minAnswerTime)
+		}
+		lockedFor := time.Duration(enforced[EnforceAnswerLock] * float64(answerLockPerIntensity))
+		if lockedFor > 0 {
+			room.AnswerLocks[player.ID] = time.UnixMilli(room.QuestionStart).Add(lockedFor)
+		}
 
-	// Broadcast question to all players with their effects
-	for _, player := range room.Players {
-			log.Printf("player effects: %+v", playerEffects[player.ID])
-
-		err := player.Conn.WriteJSON(map[string]interface{}{
-			"type":     "question",
-			"id":       question.ID,
-			"question": question.Text,
-			"options":  question.Options,
-			"effect":   playerEffects[player.ID],
+		payload := &QuestionMessage{
+			Type:          "question",
+			QuestionView:  question.View(options),
+			Round:         room.Round,
+			Effect:        playerEffects[player.ID],
+			ActiveEffects: activeEffects[player.ID],
+			TimeLimit:     int((questionTimeout + room.TimeBonus[player.ID]).Seconds()),
+			LockedFor:     int(lockedFor.Seconds()),
+			PowerUps:      room.PowerUps[player.ID],
+		}
+
+		// Lagged players get the question late but the clock is already running
+		if lag := time.Duration(enforced[EnforceLag] * float64(lagPerIntensity)); lag > 0 {
+			room.Schedule(lag, func() {
+				if room.Round == round && room.Question != nil {
+					payload.TimeLimit = int(time.Until(room.AnswerDeadline(player)).Seconds())
+					player.Send(payload)
+				}
+			})
+			continue
+		}
+		if err := player.Send(payload); err != nil {
+			room.Log().Warn("Error sending question", "client", player.ID, "err", err)
+		}
+	}
+	spectatorOptions, spectatorOptionIDs := shuffleOptions(question.Options)
+	for _, spectator := range room.Spectators() {
+		room.OptionIDs[spectator.ID] = spectatorOptionIDs
+		spectator.Send(&QuestionMessage{
+			Type:          "question",
+			QuestionView:  question.View(spectatorOptions),
+			Round:         room.Round,
+			ActiveEffects: []ActiveEffect{}, // Spectators suffer none themselves
+			PlayerEffects: activeEffects,
+			Spectator:     true,
 		})
-		if err != nil {
-			log.Printf("error sending question to client %s: %v", player.ID, err)
+	}
+	room.logEvent("question", map[string]interface{}{
+		"round":         room.Round,
+		"questionId":    question.ID,
+		"question":      question.Text,
+		"options":       question.Options,
+		"correctAnswer": question.Answer,
+		"optionIds":     room.OptionIDs,
+		"effects":       activeEffects,
+		"timeBonus":     room.TimeBonus,
+	})
+	room.TickEffects() // This question used up a round of every effect
+	room.scheduleRoundEnd(room.Round)
+}
+
+// View returns the client-facing version of q showing options
+func (q *Question) View(options []Option) QuestionView {
+	return QuestionView{
+		ID:      q.ID,
+		Text:    q.Text,
+		Options: options,
+	}
+}
+
+// MarshalJSON encodes q without its answer, in case a Question ever ends
+// up in a message by accident
+func (q Question) MarshalJSON() ([]byte, error) {
+	return json.Marshal(q.View(q.Options))
+}
+
+// shuffleOptions returns options in random order with fresh opaque IDs, so
+// players can't share answers by letter, and a map from each opaque ID back
+// to the option's ID in quiz.json
+func shuffleOptions(options []Option) ([]Option, map[string]string) {
+	shuffled := make([]Option, len(options))
+	optionIDs := make(map[string]string, len(options))
+	for i, j := range rand.Perm(len(options)) {
+		id := generateOptionID()
+		for optionIDs[id] != "" {
+			id = generateOptionID()
 		}
+		optionIDs[id] = options[j].ID
+		shuffled[i] = Option{ID: id, Text: options[j].Text}
 	}
-	room.PlayerEffects = make(map[string][]*Sabotage) // Reset player effects for the new round
+	return shuffled, optionIDs
 }
 
-func (room *Room) CheckGameOver() {
-	activePlayers := 0
-	var lastPlayer *Client
+// scheduleRoundEnd closes round once every player's answer time is up.
+// Extra time granted during the question pushes the deadline back.
+func (room *Room) scheduleRoundEnd(round int) {
+	room.Schedule(time.Until(room.RoundDeadline()), func() {
+		if room.Question == nil || room.Round != round {
+			return // Everyone answered in time
+		}
+		if time.Now().Before(room.RoundDeadline()) {
+			room.scheduleRoundEnd(round)
+			return
+		}
+		room.FinishRound()
+	})
+}
+
+// AnswerDeadline is when c's time to answer the current question runs out
+func (room *Room) AnswerDeadline(c *Client) time.Time {
+	return time.UnixMilli(room.QuestionStart).Add(questionTimeout + room.TimeBonus[c.ID])
+}
+
+// RoundDeadline is the latest answer deadline among the active players
+func (room *Room) RoundDeadline() time.Time {
+	deadline := time.UnixMilli(room.QuestionStart)
+	for _, c := range room.ActivePlayers() {
+		if d := room.AnswerDeadline(c); d.After(deadline) {
+			deadline = d
+		}
+	}
+	return deadline
+}
 
+// PlayerAnswer returns c's answer to the current question, if any
+func (room *Room) PlayerAnswer(c *Client) *PlayerAnswer {
+	for _, ans := range room.AnswerLog {
+		if ans.Client == c {
+			return ans
+		}
+	}
+	return nil
+}
+
+// AllAnswersLocked reports whether every active player has a final answer
+func (room *Room) AllAnswersLocked() bool {
+	for _, c := range room.ActivePlayers() {
+		if ans := room.PlayerAnswer(c); ans == nil || !ans.Locked {
+			return false
+		}
+	}
+	return true
+}
+
+// FinishRound closes the current question: players that didn't answer in
+// time lose the round, health and streaks are updated and everyone gets
+// the result before the game moves on
+func (room *Room) FinishRound() {
+	question := room.Question
+	// Observed once the answers are final, players may have changed theirs
+	for _, pa := range room.AnswerLog {
+		answerLatencySeconds.Observe(float64(pa.AnswerTime) / 1000)
+	}
+	for _, player := range room.ActivePlayers() {
+		if room.PlayerAnswer(player) == nil {
+			room.AnswerLog = append(room.AnswerLog, &PlayerAnswer{
+				Client:     player,
+				Answer:     "",
+				Correct:    false,
+				AnswerTime: 1<<63 - 1, // Max int64, so always slowest
+			})
+		}
+	}
+	room.Question = nil // No more answers for this round
+	observeSince(roundDurationSeconds, time.UnixMilli(room.QuestionStart))
+
+	result := room.EvaluateRoundResults()
+	if result.Winner == nil || result.Winner.Client == nil {
+		room.Log().Debug("No winner this round")
+	}
+	room.Log().Debug("Round evaluated", "round", room.Round, "losers", len(result.Losers))
+	room.CalculateHealth(result.Winner, result.Losers)
+	room.UpdateStreaks(room.AnswerLog)
+	room.recordRound(question, result)
+	recordQuestionStats(question, room.AnswerLog)
+
+	loserNames := []string{}
+	for _, l := range result.Losers {
+		if l != nil && l.Client != nil {
+			loserNames = append(loserNames, l.Client.Name)
+		}
+	}
+	winnerName := ""
+	if result.Winner != nil && result.Winner.Client != nil {
+		winnerName = result.Winner.Client.Name
+	}
+
+	// Reveal the answer and what everyone picked now that the round is over
+	optionText := make(map[string]string)
+	for _, o := range question.Options {
+		optionText[o.ID] = o.Text
+	}
+	answers := []AnswerReveal{}
+	for _, pa := range room.AnswerLog {
+		reveal := AnswerReveal{
+			ID:      pa.Client.ID,
+			Name:    pa.Client.Name,
+			Answer:  optionText[pa.Answer],
+			Correct: pa.Correct,
+		}
+		if pa.Answer != "" {
+			reveal.Time = &pa.AnswerTime
+		}
+		answers = append(answers, reveal)
+	}
+
+	for _, c := range room.Members() {
+		// Everyone saw their own option IDs, so point at the right one
+		correctID := ""
+		for opaqueID, optionID := range room.OptionIDs[c.ID] {
+			if optionID == question.Answer {
+				correctID = opaqueID
+			}
+		}
+		err := c.Send(map[string]interface{}{
+			"type":   "round_result",
+			"winner": winnerName,
+			"losers": loserNames,
+			"correctAnswer": map[string]string{
+				"id":   correctID,
+				"text": optionText[question.Answer],
+			},
+			"answers": answers,
+		})
+		if err != nil {
+			room.Log().Warn("Error sending round_result", "client", c.ID, "err", err)
+		}
+	}
+	room.Schedule(3*time.Second, func() {
+		if room.CheckGameOver() {
+			return
+		}
+		room.AssignSabotagesToLosers(result)
+	})
+}
+
+// CheckGameOver ends the game once at most one player is left standing
+// and reports whether it did. If the last players were all eliminated in
+// the same round the room's tie-break rule decides between a sudden-death
+// round and a shared victory.
+func (room *Room) CheckGameOver() bool {
+	var alive []*Client
+	var tied []*Client
 	for _, client := range room.Players {
 		if client == nil {
 			continue // Defensive: skip nil clients
 		}
 		if client.Health > 0 {
-			activePlayers++
-			lastPlayer = client
+			alive = append(alive, client)
+		} else if client.EliminatedRound == room.Round {
+			tied = append(tied, client)
 		}
 	}
 
-	if activePlayers <= 1 {
-		log.Println("Game over!")
-		// Broadcast winner (if any)
-		if activePlayers == 1 && lastPlayer != nil {
+	if len(alive) > 1 {
+		return false
+	}
 
-			lastPlayer.Conn.WriteJSON(map[string]interface{}{
-				"type": "game_over",
-				"note": "You win!",
-			})
+	winners := alive
+	if len(alive) == 0 && len(tied) > 0 {
+		if len(tied) > 1 && room.Settings.TieBreak != TieBreakShared && room.SuddenDeathRounds < maxSuddenDeathRounds {
+			room.StartSuddenDeath(tied)
+			return false
 		}
+		winners = tied
+	}
+
+	room.Log().Info("Game over", "round", room.Round, "winners", len(winners))
+	placements := computePlacements(room.standings(), room.Round)
+	room.EndGame(winners, placements)
+
+	winnerNames := []string{}
+	for _, w := range winners {
+		winnerNames = append(winnerNames, w.Name)
+	}
 
-		// Notify all clients
-		for _, client := range room.Players {
-			if client == nil || client == lastPlayer {
-				continue // Defensive: skip nil clients and winner
+	for _, client := range room.Members() {
+		note := "Nobody wins!"
+		switch {
+		case len(winners) == 1 && client == winners[0]:
+			note = "You win!"
+		case len(winners) == 1:
+			note = winners[0].Name + " wins!"
+		case slices.Contains(winners, client):
+			note = "You share the victory!"
+		case len(winners) > 1:
+			note = strings.Join(winnerNames, " and ") + " share the victory!"
+		}
+		client.Send(map[string]interface{}{
+			"type":       "game_over",
+			"gameId":     room.GameID,
+			"note":       note,
+			"winners":    winnerNames,
+			"placements": placements,
+		})
+	}
+	return true
+}
+
+// StartSuddenDeath brings the tied players back with one health each so the
+// next question decides the winner
+func (room *Room) StartSuddenDeath(tied []*Client) {
+	room.SuddenDeathRounds++
+	names := []string{}
+	for _, c := range tied {
+		c.Health = 1
+		c.IsSpectator = false
+		c.EliminatedRound = 0
+		room.logHealth(c, 0, "sudden_death")
+		names = append(names, c.Name)
+	}
+	room.Log().Info("Sudden death", "suddenDeathRound", room.SuddenDeathRounds, "players", names)
+
+	room.Broadcast(map[string]interface{}{
+		"type":    "sudden_death",
+		"round":   room.SuddenDeathRounds,
+		"players": names,
+	})
+	room.Broadcast(map[string]interface{}{
+		"type":    "player_update",
+		"players": room.PlayerInfo(),
+	})
+}
+
+// Placement is a player's final standing. Players eliminated in the same
+// round share a place, and the places below them are skipped. Players who
+// left count as eliminated in the round they left.
+type Placement struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Place  int    `json:"place"`
+	Health int    `json:"health"`
+}
+
+// computePlacements ranks players by how long they survived: players still
+// standing first, then by the round they were eliminated in
+func computePlacements(players []*Client, round int) []Placement {
+	survived := func(c *Client) int {
+		if c.Health > 0 {
+			return round + 1
+		}
+		return c.EliminatedRound
+	}
+
+	ranked := []*Client{}
+	for _, c := range players {
+		if c != nil {
+			ranked = append(ranked, c)
+		}
+	}
+	slices.SortStableFunc(ranked, func(a, b *Client) int {
+		return survived(b) - survived(a)
+	})
+
+	placements := make([]Placement, len(ranked))
+	for i, c := range ranked {
+		place := i + 1
+		if i > 0 && survived(c) == survived(ranked[i-1]) {
+			place = placements[i-1].Place
+		}
+		placements[i] = Placement{
+			ID:     c.ID,
+			Name:   c.Name,
+			Place:  place,
+			Health: c.Health,
+		}
+	}
+	return placements
+}
+
+// ActivePlayers returns the players that are still in the game
+func (room *Room) ActivePlayers() []*Client {
+	active := []*Client{}
+	for _, c := range room.Players {
+		if !c.IsSpectator {
+			active = append(active, c)
+		}
+	}
+	return active
+}
+
+// Spectators returns everyone watching the room: eliminated players
+// followed by those who joined as spectators
+func (room *Room) Spectators() []*Client {
+	watching := []*Client{}
+	for _, c := range room.Players {
+		if c.IsSpectator {
+			watching = append(watching, c)
+		}
+	}
+	return append(watching, room.Watchers...)
+}
+
+// Members returns every player and spectator in the room
+func (room *Room) Members() []*Client {
+	members := append([]*Client{}, room.Players...)
+	return append(members, room.Watchers...)
+}
+
+// PlayerInfo returns the public state of every player for status messages
+func (room *Room) PlayerInfo() []map[string]interface{} {
+	players := []map[string]interface{}{}
+	for _, c := range room.Players {
+		players = append(players, map[string]interface{}{
+			"id":        c.ID,
+			"name":      c.Name,
+			"health":    c.Health,
+			"spectator": c.IsSpectator,
+		})
+	}
+	return players
+}
+
+// Send writes msg to the client's connection
+func (c *Client) Send(msg interface{}) error {
+	c.ConnMutex.Lock()
+	defer c.ConnMutex.Unlock()
+	err := c.Conn.WriteJSON(msg)
+	if err != nil {
+		writeErrors.Inc()
+	}
+	return err
+}
+
+// Broadcast sends msg to every player and spectator in the room
+func (room *Room) Broadcast(msg interface{}) {
+	for _, c := range room.Members() {
+		if err := c.Send(msg); err != nil {
+			room.Log().Warn("Error broadcasting", "client", c.ID, "err", err)
+		}
+	}
+}
+
+// ResetForRematch puts the room back in the lobby with fresh health,
+// sabotage inventories and question deck. The host stays the same.
+func (room *Room) ResetForRematch() {
+	room.Phase = PhaseLobby
+	room.Round = 0
+	room.QuestionDeck = nil
+	room.Question = nil
+	room.QuestionStart = 0
+	room.AnswerLog = []*PlayerAnswer{}
+	room.SabotageSelection = nil
+	room.RematchVotes = nil
+	room.AvailableSabotages = make(map[string][]*Sabotage)
+	room.PlayerEffects = make(map[string][]*Sabotage)
+
+	room.SuddenDeathRounds = 0
+	room.Items = nil
+	room.ArmedItems = nil
+	room.Streaks = nil
+	room.PowerUps = nil
+	room.RewardSelection = nil
+	for _, c := range room.Players {
+		c.Health = startingHealth
+		c.IsSpectator = false
+		c.EliminatedRound = 0
+		room.AvailableSabotages[c.ID] = GenerateInitialSabotageList()
+		room.PlayerEffects[c.ID] = []*Sabotage{}
+	}
+	room.Log().Info("Room reset for a rematch", "players", len(room.Players))
+}
+
+// CheckRematch tells the room how many players want a rematch and starts it
+// once every remaining player has accepted
+func (room *Room) CheckRematch() {
+	accepted := 0
+	for _, c := range room.Players {
+		if room.RematchVotes[c.ID] {
+			accepted++
+		}
+	}
+	room.Broadcast(map[string]interface{}{
+		"type":     "rematch_vote",
+		"accepted": accepted,
+		"needed":   len(room.Players),
+	})
+
+	if accepted < len(room.Players) {
+		return
+	}
+
+	room.ResetForRematch()
+	for _, c := range room.Members() {
+		c.Send(map[string]interface{}{
+			"type":     "rematch_started",
+			"roomCode": room.RoomCode,
+			"isHost":   c.IsHost,
+			"id":       c.ID,
+		})
+	}
+	broadcastPlayerCount(room)
+}
+
+// Schedule runs fn after d while holding clientsMutex. The call is dropped if
+// the game has ended or a new one started in the meantime.
+func (room *Room) Schedule(d time.Duration, fn func()) {
+	gameID := room.GameID
+	timer := time.AfterFunc(d, func() {
+		defer func() {
+			if r := recover(); r != nil {
+				slog.Error("Recovered in scheduled task", "room", room.RoomCode, "panic", r)
 			}
-			client.Conn.WriteJSON(map[string]interface{}{
-				"type": "game_over",
-				"note": lastPlayer.Name + " wins!",
-			})
+		}()
+		clientsMutex.Lock()
+		defer clientsMutex.Unlock()
+
+		if room.Phase != PhasePlaying || room.GameID != gameID {
+			return
 		}
+		fn()
+	})
+	room.Timers = append(room.Timers, timer)
+}
+
+// StopTimers cancels all work scheduled for the room
+func (room *Room) StopTimers() {
+	for _, t := range room.Timers {
+		t.Stop()
+	}
+	room.Timers = nil
+}
+
+// Close stops the room's timers and marks it closed, so callbacks that
+// already fired and are waiting on clientsMutex skip it. The caller removes
+// it from rooms.
+func (room *Room) Close() {
+	room.StopTimers()
+	room.Phase = PhaseClosed
+}
+
+// EndGame stops the game loop, records the result and clears per-game state.
+// winners is empty when nobody is left standing.
+func (room *Room) EndGame(winners []*Client, placements []Placement) {
+	room.StopTimers()
+	room.Phase = PhaseGameOver
+	gamesFinished.Inc()
+	room.RematchVotes = make(map[string]bool)
+
+	result := &GameResult{
+		GameID:    room.GameID,
+		RoomCode:  room.RoomCode,
+		StartedAt: room.StartedAt,
+		EndedAt:   time.Now(),
+		Rounds:    room.Round,
+		Players:   resultPlayers(placements),
+	}
+	for _, w := range winners {
+		result.WinnerIDs = append(result.WinnerIDs, w.ID)
+	}
+	if room.Match != nil {
+		room.Match.GameResult = *result
+		saveMatch(room.Match)
+		room.Match = nil
 	}
+	room.logEvent("game_over", result)
+	room.closeReplay("")
+
+	room.Question = nil
+	room.QuestionDeck = nil
+	room.AnswerLog = []*PlayerAnswer{}
+	room.SabotageSelection = nil
+	room.RewardSelection = nil
+	room.PlayerEffects = make(map[string][]*Sabotage)
 }
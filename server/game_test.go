@@ -0,0 +1,612 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestClient returns a client backed by a real WebSocket connection and a
+// channel of the messages it receives
+func newTestClient(t *testing.T, id string, health, eliminatedRound int) (*Client, <-chan map[string]interface{}) {
+	t.Helper()
+	serverConns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		serverConns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { peer.Close() })
+
+	received := make(chan map[string]interface{}, 32)
+	go func() {
+		defer close(received)
+		for {
+			var msg map[string]interface{}
+			if err := peer.ReadJSON(&msg); err != nil {
+				return
+			}
+			received <- msg
+		}
+	}()
+
+	client := &Client{
+		ID:              id,
+		Name:            id,
+		Conn:            <-serverConns,
+		Health:          health,
+		IsSpectator:     health == 0,
+		EliminatedRound: eliminatedRound,
+	}
+	return client, received
+}
+
+// waitFor returns the first message of type msgType, failing the test if
+// none arrives
+func waitFor(t *testing.T, received <-chan map[string]interface{}, msgType string) map[string]interface{} {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case msg, ok := <-received:
+			if !ok {
+				t.Fatalf("connection closed waiting for %s", msgType)
+			}
+			if msg["type"] == msgType {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("no %s message", msgType)
+		}
+	}
+}
+
+// waitForError returns the first error message, failing the test if none
+// arrives
+func waitForError(t *testing.T, received <-chan map[string]interface{}) string {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case msg, ok := <-received:
+			if !ok {
+				t.Fatalf("connection closed waiting for an error")
+			}
+			if err, isError := msg["error"].(string); isError {
+				return err
+			}
+		case <-timeout:
+			t.Fatalf("no error message")
+		}
+	}
+}
+
+func TestCheckGameOver(t *testing.T) {
+	type player struct {
+		id              string
+		health          int
+		eliminatedRound int
+	}
+	tests := []struct {
+		name              string
+		tieBreak          string
+		suddenDeathRounds int
+		players           []player
+		wantOver          bool
+		wantWinners       []string
+		wantSuddenDeath   []string // Players put back in with 1 health
+	}{
+		{
+			name:     "zero survivors starts sudden death",
+			tieBreak: TieBreakSuddenDeath,
+			players: []player{
+				{"a", 0, 5}, {"b", 0, 5}, {"c", 0, 3},
+			},
+			wantSuddenDeath: []string{"a", "b"},
+		},
+		{
+			name:     "zero survivors share the win",
+			tieBreak: TieBreakShared,
+			players: []player{
+				{"a", 0, 5}, {"b", 0, 5}, {"c", 0, 3},
+			},
+			wantOver:    true,
+			wantWinners: []string{"a", "b"},
+		},
+		{
+			name:              "sudden death limit falls back to a shared win",
+			tieBreak:          TieBreakSuddenDeath,
+			suddenDeathRounds: maxSuddenDeathRounds,
+			players: []player{
+				{"a", 0, 5}, {"b", 0, 5},
+			},
+			wantOver:    true,
+			wantWinners: []string{"a", "b"},
+		},
+		{
+			name:     "one survivor wins",
+			tieBreak: TieBreakSuddenDeath,
+			players: []player{
+				{"a", 2, 0}, {"b", 0, 5}, {"c", 0, 4},
+			},
+			wantOver:    true,
+			wantWinners: []string{"a"},
+		},
+		{
+			name:     "two survivors keep playing",
+			tieBreak: TieBreakSuddenDeath,
+			players: []player{
+				{"a", 2, 0}, {"b", 1, 0}, {"c", 0, 4},
+			},
+		},
+		{
+			name:     "three survivors keep playing",
+			tieBreak: TieBreakShared,
+			players: []player{
+				{"a", 5, 0}, {"b", 1, 0}, {"c", 3, 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := &Room{
+				RoomCode:          "TEST",
+				Settings:          RoomSettings{TieBreak: tt.tieBreak},
+				Phase:             PhasePlaying,
+				Round:             5,
+				SuddenDeathRounds: tt.suddenDeathRounds,
+			}
+			inboxes := make(map[string]<-chan map[string]interface{})
+			for _, p := range tt.players {
+				client, received := newTestClient(t, p.id, p.health, p.eliminatedRound)
+				room.Players = append(room.Players, client)
+				inboxes[p.id] = received
+			}
+
+			clientsMutex.Lock()
+			over := room.CheckGameOver()
+			clientsMutex.Unlock()
+
+			if over != tt.wantOver {
+				t.Fatalf("CheckGameOver() = %t, want %t", over, tt.wantOver)
+			}
+			if tt.wantOver {
+				if room.Phase != PhaseGameOver {
+					t.Errorf("phase = %s, want %s", room.Phase, PhaseGameOver)
+				}
+				msg := waitFor(t, inboxes[tt.players[0].id], "game_over")
+				winners := []string{}
+				for _, w := range msg["winners"].([]interface{}) {
+					winners = append(winners, w.(string))
+				}
+				if !slices.Equal(winners, tt.wantWinners) {
+					t.Errorf("winners = %v, want %v", winners, tt.wantWinners)
+				}
+				return
+			}
+
+			if room.Phase != PhasePlaying {
+				t.Errorf("phase = %s, want %s", room.Phase, PhasePlaying)
+			}
+			wantRounds := tt.suddenDeathRounds
+			if len(tt.wantSuddenDeath) > 0 {
+				wantRounds++
+				waitFor(t, inboxes[tt.wantSuddenDeath[0]], "sudden_death")
+			}
+			if room.SuddenDeathRounds != wantRounds {
+				t.Errorf("SuddenDeathRounds = %d, want %d", room.SuddenDeathRounds, wantRounds)
+			}
+			for _, c := range room.Players {
+				if slices.Contains(tt.wantSuddenDeath, c.ID) && (c.Health != 1 || c.IsSpectator) {
+					t.Errorf("%s has health %d, spectator %t, want 1 and back in", c.ID, c.Health, c.IsSpectator)
+				}
+			}
+		})
+	}
+}
+
+func TestComputePlacements(t *testing.T) {
+	players := []*Client{
+		{ID: "out-early", Health: 0, EliminatedRound: 2},
+		{ID: "alive-low", Health: 1},
+		{ID: "out-late-1", Health: 0, EliminatedRound: 4},
+		{ID: "alive-high", Health: 3},
+		{ID: "out-late-2", Health: 0, EliminatedRound: 4},
+	}
+
+	placements := computePlacements(players, 5)
+
+	// Survivors tie for first, the two round 4 players share third and
+	// nobody is fourth
+	want := map[string]int{
+		"alive-low":  1,
+		"alive-high": 1,
+		"out-late-1": 3,
+		"out-late-2": 3,
+		"out-early":  5,
+	}
+	if len(placements) != len(want) {
+		t.Fatalf("got %d placements, want %d", len(placements), len(want))
+	}
+	for i, p := range placements {
+		if p.Place != want[p.ID] {
+			t.Errorf("%s placed %d, want %d", p.ID, p.Place, want[p.ID])
+		}
+		if i > 0 && p.Place < placements[i-1].Place {
+			t.Errorf("placements out of order: %v", placements)
+		}
+	}
+}
+
+func TestLeavingEndsGame(t *testing.T) {
+	room := &Room{
+		RoomCode: "TEST",
+		Settings: RoomSettings{TieBreak: TieBreakSuddenDeath},
+		Phase:    PhasePlaying,
+		Round:    3,
+	}
+	stayer, received := newTestClient(t, "stayer", 1, 0)
+	leaver, _ := newTestClient(t, "leaver", 2, 0)
+	out, _ := newTestClient(t, "out", 0, 2)
+	room.Match = &MatchRecord{}
+	for _, c := range []*Client{stayer, leaver, out} {
+		c.Room = room
+		room.Players = append(room.Players, c)
+		room.Match.Roster = append(room.Match.Roster, RosterPlayer{ID: c.ID, Name: c.Name})
+	}
+
+	clientsMutex.Lock()
+	removeClientFromRoom(leaver)
+	clientsMutex.Unlock()
+
+	if room.Phase != PhaseGameOver {
+		t.Fatalf("phase = %s, want %s", room.Phase, PhaseGameOver)
+	}
+	msg := waitFor(t, received, "game_over")
+	if winners := msg["winners"].([]interface{}); len(winners) != 1 || winners[0] != "stayer" {
+		t.Errorf("winners = %v, want [stayer]", winners)
+	}
+
+	// The leaver is placed by the round they left in, above the player
+	// eliminated before that
+	want := map[string]float64{"stayer": 1, "leaver": 2, "out": 3}
+	placements := msg["placements"].([]interface{})
+	if len(placements) != len(want) {
+		t.Fatalf("placements = %v, want %d", placements, len(want))
+	}
+	for _, p := range placements {
+		p := p.(map[string]interface{})
+		if p["place"] != want[p["id"].(string)] {
+			t.Errorf("%s placed %v, want %v", p["id"], p["place"], want[p["id"].(string)])
+		}
+	}
+}
+
+func TestLeavingStartsRematch(t *testing.T) {
+	room := &Room{
+		RoomCode:     "TEST",
+		Phase:        PhaseGameOver,
+		RematchVotes: make(map[string]bool),
+	}
+	voter, received := newTestClient(t, "voter", 2, 0)
+	holdout, _ := newTestClient(t, "holdout", 0, 3)
+	for _, c := range []*Client{voter, holdout} {
+		c.Room = room
+		room.Players = append(room.Players, c)
+	}
+	room.RematchVotes[voter.ID] = true
+
+	clientsMutex.Lock()
+	removeClientFromRoom(holdout)
+	clientsMutex.Unlock()
+
+	if room.Phase != PhaseLobby {
+		t.Fatalf("phase = %s, want %s", room.Phase, PhaseLobby)
+	}
+	waitFor(t, received, "rematch_started")
+	if voter.Health != startingHealth {
+		t.Errorf("health = %d, want %d", voter.Health, startingHealth)
+	}
+}
+
+func TestRematchDuringShutdown(t *testing.T) {
+	room := &Room{
+		RoomCode:     "TEST",
+		Phase:        PhaseGameOver,
+		RematchVotes: make(map[string]bool),
+	}
+	accepter, accepted := newTestClient(t, "accepter", 2, 0)
+	decliner, declined := newTestClient(t, "decliner", 0, 3)
+	for _, c := range []*Client{accepter, decliner} {
+		c.Room = room
+		room.Players = append(room.Players, c)
+	}
+
+	clientsMutex.Lock()
+	shuttingDown = true
+	handleRematch(accepter, Message{Accept: true}, accepter.Conn)
+	handleRematch(decliner, Message{Accept: false}, decliner.Conn)
+	shuttingDown = false
+	clientsMutex.Unlock()
+
+	if msg := <-accepted; msg["error"] != "Server is shutting down" {
+		t.Errorf("accept got %v, want the shutdown error", msg)
+	}
+	if room.RematchVotes[accepter.ID] {
+		t.Errorf("accept vote recorded during shutdown")
+	}
+	// Declining still leaves the room
+	waitFor(t, declined, "left_room")
+	if decliner.Room != nil || slices.Contains(room.Players, decliner) {
+		t.Errorf("decliner still in the room")
+	}
+}
+
+func TestClosedRoomSkipsPendingTimers(t *testing.T) {
+	last, _ := newTestClient(t, "last", 3, 0)
+	room := &Room{RoomCode: "TEST", Phase: PhasePlaying, GameID: "game-1", Players: []*Client{last}}
+	last.Room = room
+	roomsMutex.Lock()
+	rooms[room.RoomCode] = room
+	roomsMutex.Unlock()
+
+	ran := false
+	clientsMutex.Lock()
+	room.Schedule(time.Millisecond, func() { ran = true })
+	// The timer fires and waits on clientsMutex while the room is emptied
+	time.Sleep(50 * time.Millisecond)
+	removeClientFromRoom(last)
+	clientsMutex.Unlock()
+	time.Sleep(50 * time.Millisecond)
+
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	if ran {
+		t.Errorf("scheduled callback ran in a deleted room")
+	}
+	if room.Phase != PhaseClosed {
+		t.Errorf("phase = %s, want %s", room.Phase, PhaseClosed)
+	}
+}
+
+func TestSabotageAfterRewardKeepsDeadline(t *testing.T) {
+	winner, winnerReceived := newTestClient(t, "winner", 3, 0)
+	loser, loserReceived := newTestClient(t, "loser", 2, 0)
+	room := &Room{
+		RoomCode:           "TEST",
+		Phase:              PhasePlaying,
+		Settings:           RoomSettings{Rewards: []string{RewardSabotage, RewardPowerUp}, SabotageTimeout: 10},
+		Players:            []*Client{winner, loser},
+		AvailableSabotages: map[string][]*Sabotage{"loser": {{Name: "Blur"}}},
+	}
+	winner.Room, loser.Room = room, room
+	t.Cleanup(func() {
+		clientsMutex.Lock()
+		room.StopTimers()
+		clientsMutex.Unlock()
+	})
+	result := &RoundResult{
+		Winner: &PlayerAnswer{Client: winner},
+		Losers: []*PlayerAnswer{{Client: loser}},
+	}
+
+	clientsMutex.Lock()
+	room.OfferRewards(result)
+	deadline := room.RewardSelection.Deadline
+	clientsMutex.Unlock()
+	waitFor(t, winnerReceived, "choose_reward")
+	waitFor(t, loserReceived, "wait_winner")
+
+	time.Sleep(20 * time.Millisecond)
+	clientsMutex.Lock()
+	handleChooseReward(winner, Message{Reward: RewardSabotage}, winner.Conn)
+	clientsMutex.Unlock()
+
+	msg := waitFor(t, winnerReceived, "choose_sabotage")
+	if int64(msg["deadline"].(float64)) != deadline.UnixMilli() {
+		t.Errorf("sabotage deadline %v, want the reward deadline %d", msg["deadline"], deadline.UnixMilli())
+	}
+	clientsMutex.Lock()
+	if room.SabotageSelection == nil || !room.SabotageSelection.Deadline.Equal(deadline) {
+		t.Errorf("sabotage selection doesn't share the reward deadline")
+	}
+	clientsMutex.Unlock()
+	select {
+	case msg := <-loserReceived:
+		if msg["type"] == "wait_winner" {
+			t.Errorf("loser was sent a second wait_winner")
+		}
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestUseSabotageChecks(t *testing.T) {
+	oldRegistry := sabotageRegistry
+	t.Cleanup(func() { sabotageRegistry = oldRegistry })
+	sabotageRegistry = map[string]*SabotageDef{
+		"Blur":  {ID: "Blur", Duration: 1},
+		"Shake": {ID: "Shake", Duration: 1},
+	}
+
+	tests := []struct {
+		name    string
+		sender  string
+		earlier map[string]string // Picks made before msg
+		msg     Message
+		want    string
+	}{
+		{"not the winner", "a", nil, Message{Name: "Blur"}, "Not allowed to use sabotage"},
+		{"nothing chosen", "winner", nil, Message{}, "No sabotage chosen"},
+		{"target not pending", "winner", nil, Message{Targets: map[string]string{"winner": "Blur"}}, "Target is not waiting for a sabotage"},
+		{"unknown sabotage", "winner", nil, Message{Targets: map[string]string{"a": "Nope"}}, "Unknown sabotage"},
+		{"not offered", "winner", nil, Message{Targets: map[string]string{"a": "Shake"}}, "Sabotage was not offered"},
+		{"repeat pick", "winner", map[string]string{"a": "Blur"}, Message{Targets: map[string]string{"a": "Blur"}}, "Target is not waiting for a sabotage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			winner, winnerReceived := newTestClient(t, "winner", 3, 0)
+			a, aReceived := newTestClient(t, "a", 2, 0)
+			b, _ := newTestClient(t, "b", 2, 0)
+			room := &Room{
+				RoomCode:      "TEST",
+				Phase:         PhasePlaying,
+				Players:       []*Client{winner, a, b},
+				PlayerEffects: make(map[string][]*Sabotage),
+				SabotageSelection: &SabotageSelection{
+					WinnerID: winner.ID,
+					Choices:  map[string][]string{"a": {"Blur"}, "b": {"Blur"}},
+					Pending:  map[string]bool{"a": true, "b": true},
+					Deadline: time.Now().Add(10 * time.Second),
+				},
+			}
+			clients := map[string]*Client{"winner": winner, "a": a}
+			received := map[string]<-chan map[string]interface{}{"winner": winnerReceived, "a": aReceived}
+			for _, c := range room.Players {
+				c.Room = room
+			}
+
+			clientsMutex.Lock()
+			if tt.earlier != nil {
+				handleUseSabotage(winner, Message{Targets: tt.earlier}, winner.Conn)
+			}
+			sender := clients[tt.sender]
+			handleUseSabotage(sender, tt.msg, sender.Conn)
+			clientsMutex.Unlock()
+
+			if got := waitForError(t, received[tt.sender]); got != tt.want {
+				t.Errorf("error = %q, want %q", got, tt.want)
+			}
+			if room.SabotageSelection == nil || !room.SabotageSelection.Pending["b"] {
+				t.Errorf("selection for b closed by a refused pick")
+			}
+			if got := len(room.PlayerEffects["a"]); got != len(tt.earlier) {
+				t.Errorf("a has %d effects, want %d", got, len(tt.earlier))
+			}
+		})
+	}
+}
+
+func TestAnswerChecks(t *testing.T) {
+	tests := []struct {
+		name        string
+		noQuestion  bool
+		allowChange bool
+		earlier     string // Option answered before msg, if any
+		lock        bool   // Lock in the earlier answer
+		msg         Message
+		want        string // Error, "" if the answer is taken
+		wantAnswer  string // Answer recorded afterwards
+	}{
+		{name: "no question", noQuestion: true, msg: Message{QuestionID: 7, Answer: "x"}, want: "No question in progress"},
+		{name: "different question", msg: Message{QuestionID: 6, Answer: "x"}, want: "Answer is for a different question"},
+		{name: "first answer is final", earlier: "x", msg: Message{QuestionID: 7, Answer: "y"}, want: "You already answered this question", wantAnswer: "A"},
+		{name: "change allowed", allowChange: true, earlier: "x", msg: Message{QuestionID: 7, Answer: "y"}, wantAnswer: "B"},
+		{name: "change after lock-in", allowChange: true, earlier: "x", lock: true, msg: Message{QuestionID: 7, Answer: "y"}, want: "You already answered this question", wantAnswer: "A"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			player, received := newTestClient(t, "player", 3, 0)
+			other, _ := newTestClient(t, "other", 3, 0)
+			room := &Room{
+				RoomCode:      "TEST",
+				Phase:         PhasePlaying,
+				Settings:      RoomSettings{AllowAnswerChange: tt.allowChange},
+				Players:       []*Client{player, other},
+				Question:      &Question{ID: 7, Answer: "B"},
+				QuestionStart: time.Now().UnixMilli(),
+				OptionIDs:     map[string]map[string]string{"player": {"x": "A", "y": "B"}},
+			}
+			if tt.noQuestion {
+				room.Question = nil
+			}
+			player.Room, other.Room = room, room
+
+			clientsMutex.Lock()
+			if tt.earlier != "" {
+				handleAnswer(player, Message{QuestionID: 7, Answer: tt.earlier}, player.Conn)
+			}
+			if tt.lock {
+				handleLockAnswer(player, Message{QuestionID: 7}, player.Conn)
+			}
+			handleAnswer(player, tt.msg, player.Conn)
+			clientsMutex.Unlock()
+
+			if tt.want != "" {
+				if got := waitForError(t, received); got != tt.want {
+					t.Errorf("error = %q, want %q", got, tt.want)
+				}
+			}
+			got := ""
+			if answer := room.PlayerAnswer(player); answer != nil {
+				got = answer.Answer
+			}
+			if got != tt.wantAnswer {
+				t.Errorf("recorded answer = %q, want %q", got, tt.wantAnswer)
+			}
+		})
+	}
+}
+
+func TestGuardAction(t *testing.T) {
+	tests := []struct {
+		name   string
+		action string
+		setup  func(c *Client, room *Room) // Changes to a registered room where c plays
+		want   string
+	}{
+		{"allowed", "player_answer", func(c *Client, room *Room) {}, ""},
+		{"not in a room", "player_answer", func(c *Client, room *Room) { c.Room = nil }, "Not in any room"},
+		{"room deleted", "player_answer", func(c *Client, room *Room) { delete(rooms, room.RoomCode) }, "Not in any room"},
+		{"not a member", "player_answer", func(c *Client, room *Room) { room.Players = nil }, "Not in any room"},
+		{"wrong phase", "player_answer", func(c *Client, room *Room) { room.Phase = PhaseLobby }, "Not allowed while the room is in " + PhaseLobby},
+		{"spectator", "use_sabotage", func(c *Client, room *Room) {
+			room.Players, room.Watchers = nil, []*Client{c}
+			c.IsSpectator = true
+		}, "Spectators cannot do that"},
+		{"eliminated", "choose_reward", func(c *Client, room *Room) { c.Health = 0 }, "You've been eliminated"},
+		{"already in a room", "join", func(c *Client, room *Room) {}, "Leave your current room first"},
+		{"shutting down", "start_game", func(c *Client, room *Room) {
+			room.Phase = PhaseLobby
+			shuttingDown = true
+		}, "Server is shutting down"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := newTestClient(t, "player", 3, 0)
+			room := &Room{RoomCode: "GUARD", Phase: PhasePlaying, Players: []*Client{client}}
+			client.Room = room
+
+			clientsMutex.Lock()
+			defer clientsMutex.Unlock()
+			roomsMutex.Lock()
+			rooms[room.RoomCode] = room
+			roomsMutex.Unlock()
+			defer func() {
+				shuttingDown = false
+				roomsMutex.Lock()
+				delete(rooms, room.RoomCode)
+				roomsMutex.Unlock()
+			}()
+
+			tt.setup(client, room)
+			if got := guardAction(client, actions[tt.action]); got != tt.want {
+				t.Errorf("guardAction(%s) = %q, want %q", tt.action, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,14 +1,83 @@
 package main
 
 import (
-	"log"
 	"slices"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-func handleCreateRoom(client *Client, conn *websocket.Conn) {
+// actionSpec describes what a client needs before an action's handler runs
+type actionSpec struct {
+	handler     func(client *Client, msg Message, conn *websocket.Conn)
+	outsideRoom bool     // Client must not be in a room yet
+	inRoom      bool     // Client must be in a room
+	phases      []string // Room phases the action is allowed in, any if empty
+	player      bool     // Spectators and eliminated players are refused
+	opens       bool     // Joins a room or starts a game, refused during shutdown
+	replay      bool     // Allowed while watching a replay, nothing else is
+}
+
+var actions = map[string]actionSpec{
+	"create":            {handler: handleCreateRoom, outsideRoom: true, opens: true},
+	"join":              {handler: handleJoinRoom, outsideRoom: true, opens: true},
+	"spectate":          {handler: handleSpectateRoom, outsideRoom: true, opens: true},
+	"find_match":        {handler: handleFindMatch, outsideRoom: true, opens: true},
+	"cancel_find_match": {handler: handleCancelFindMatch, outsideRoom: true},
+	"resume":            {handler: handleResume, outsideRoom: true, opens: true},
+	"list_rooms":        {handler: handleListRooms},
+	"identify":          {handler: handleIdentify, outsideRoom: true},
+	"get_profile":       {handler: handleGetProfile},
+	"replay":            {handler: handleReplay, outsideRoom: true},
+	"replay_step":       {handler: handleReplayStep, replay: true},
+	"replay_stop":       {handler: handleReplayStop, replay: true},
+	"leave_room":        {handler: handleLeaveRoom, inRoom: true},
+	"start_game":        {handler: handleStartGame, inRoom: true, phases: []string{PhaseLobby}, opens: true},
+	"player_answer":     {handler: handleAnswer, inRoom: true, phases: []string{PhasePlaying}, player: true},
+	"lock_answer":       {handler: handleLockAnswer, inRoom: true, phases: []string{PhasePlaying}, player: true},
+	"use_sabotage":      {handler: handleUseSabotage, inRoom: true, phases: []string{PhasePlaying}, player: true},
+	"choose_reward":     {handler: handleChooseReward, inRoom: true, phases: []string{PhasePlaying}, player: true},
+	"use_power_up":      {handler: handleUsePowerUp, inRoom: true, phases: []string{PhasePlaying}, player: true},
+	"use_item":          {handler: handleUseItem, inRoom: true, phases: []string{PhasePlaying}, player: true},
+	// Eliminated players vote too, so this isn't limited to active players.
+	// Declining only leaves the room, so shutdown is checked in the handler.
+	"rematch": {handler: handleRematch, inRoom: true, phases: []string{PhaseGameOver}},
+}
+
+// guardAction checks room membership, phase and elimination for spec. It
+// returns the error to send the client, or "" if the action may go ahead.
+func guardAction(client *Client, spec actionSpec) string {
+	room := client.Room
+	if spec.opens && shuttingDown {
+		return "Server is shutting down"
+	}
+	if client.Replay != nil && !spec.replay {
+		return "Stop the replay first"
+	}
+	if spec.outsideRoom && room != nil {
+		return "Leave your current room first"
+	}
+	if spec.inRoom {
+		// The room comes from the connection, never from the message
+		if room == nil || rooms[room.RoomCode] != room || !slices.Contains(room.Members(), client) {
+			return "Not in any room"
+		}
+		if len(spec.phases) > 0 && !slices.Contains(spec.phases, room.Phase) {
+			return "Not allowed while the room is in " + room.Phase
+		}
+	}
+	if spec.player {
+		if client.IsSpectator {
+			return "Spectators cannot do that"
+		}
+		if client.Health <= 0 {
+			return "You've been eliminated"
+		}
+	}
+	return ""
+}
+
+func handleCreateRoom(client *Client, msg Message, conn *websocket.Conn) {
 	removePlayerFromQueue(client)
 
 	roomCode := generateRoomCode()
@@ -20,13 +89,46 @@ func handleCreateRoom(client *Client, conn *websocket.Conn) {
 		roomCode = generateRoomCode()
 	}
 
+	settings := defaultRoomSettings()
+	if msg.Settings != nil {
+		settings.Private = msg.Settings.Private
+		if msg.Settings.TieBreak == TieBreakShared {
+			settings.TieBreak = TieBreakShared
+		}
+		switch msg.Settings.EffectStacking {
+		case StackingIntensity, StackingReject:
+			settings.EffectStacking = msg.Settings.EffectStacking
+		}
+		if msg.Settings.SabotageTimeout != 0 {
+			settings.SabotageTimeout = max(minSabotageTimeout, min(msg.Settings.SabotageTimeout, maxSabotageTimeout))
+		}
+		rewards := []string{}
+		for _, r := range msg.Settings.Rewards {
+			if slices.Contains(allRewards, r) && !slices.Contains(rewards, r) {
+				rewards = append(rewards, r)
+			}
+		}
+		if len(rewards) > 0 {
+			settings.Rewards = rewards
+		}
+		settings.AllowAnswerChange = msg.Settings.AllowAnswerChange
+	}
+	// Only private rooms can be password protected
+	if settings.Private {
+		settings.Password = msg.Password
+	}
+
 	// client.RoomCode = roomCode
 	client.IsHost = true
-	client.Health = 5 // Reset health for new room
+	client.IsSpectator = false
+	client.EliminatedRound = 0
+	client.Health = startingHealth // Reset health for new room
 	// clientsPerRoom[roomCode] = []*Client{client}
 	newRoom := &Room{
 		Players:           []*Client{client},
 		RoomCode:          roomCode,
+		Settings:          settings,
+		Phase:             PhaseLobby,
 		Question:          nil,
 		SabotageSelection: nil,
 		AnswerLog:         []*PlayerAnswer{},
@@ -46,43 +148,54 @@ func handleCreateRoom(client *Client, conn *websocket.Conn) {
 	roomsMutex.Lock()
 	rooms[roomCode] = newRoom
 	roomsMutex.Unlock()
+	client.Room = newRoom
+	newRoom.LastActivity = time.Now()
 
-	log.Printf("Room %s created by %s (%s)\n", roomCode, client.Name, client.ID)
+	client.Log().Info("Room created", "private", settings.Private)
 
-	err := conn.WriteJSON(map[string]interface{}{
+	err := client.Send(map[string]interface{}{
 		"type":     "room_created",
 		"roomCode": roomCode,
 		"id":       client.ID,
 		"name":     client.Name,
+		"settings": settings,
 	})
 	if err != nil {
-		log.Printf("Error sending room_created message: %v\n", err)
+		client.Log().Warn("Error sending room_created", "err", err)
 	}
 }
 
-func handleJoinRoom(client *Client, conn *websocket.Conn, msg Message) {
+func handleJoinRoom(client *Client, msg Message, conn *websocket.Conn) {
 	removePlayerFromQueue(client)
 
 	if msg.Room == "" {
-		conn.WriteJSON(map[string]string{"error": "Room code required to join"})
-		clientsMutex.Unlock()
+		client.Send(map[string]string{"error": "Room code required to join"})
 		return
 	}
 	room, exists := rooms[msg.Room]
 	if !exists {
-		conn.WriteJSON(map[string]string{"error": "Room does not exist"})
-		clientsMutex.Unlock()
+		client.Send(map[string]string{"error": "Room does not exist"})
+		return
+	}
+	if room.Settings.Password != "" && msg.Password != room.Settings.Password {
+		client.Send(map[string]string{"error": "Incorrect room password"})
+		return
+	}
+	if room.Phase != PhaseLobby {
+		client.Send(map[string]string{"error": "Game already in progress, you can spectate instead"})
 		return
 	}
-	if len(room.Players) >= 4 {
-		conn.WriteJSON(map[string]string{"error": "Room is full"})
-		clientsMutex.Unlock()
+	if len(room.Players) >= maxPlayers {
+		client.Send(map[string]string{"error": "Room is full"})
 		return
 	}
 
 	// client.RoomCode = msg.Room
 	client.IsHost = false
-	client.Health = 5 // Reset health when joining a room
+	client.IsSpectator = false
+	client.EliminatedRound = 0
+	client.Health = startingHealth // Reset health when joining a room
+	client.Room = room
 	room.Players = append(room.Players, client)
 
 	// Fix: Initialize both available sabotages and effects
@@ -93,38 +206,32 @@ func handleJoinRoom(client *Client, conn *websocket.Conn, msg Message) {
 	// room := rooms[msg.Room]
 	// roomsMutex.RUnlock()
 
-	conn.WriteJSON(map[string]interface{}{
+	client.Send(map[string]interface{}{
 		"type": "joined",
 	})
 
-	log.Printf("%s (%s) joined room %s\n", client.Name, client.ID, msg.Room)
+	client.Log().Info("Joined room")
 	broadcastPlayerCount(room)
 }
 
-func handleFindMatch(client *Client, conn *websocket.Conn) {
+func handleFindMatch(client *Client, msg Message, conn *websocket.Conn) {
 	removePlayerFromQueue(client)
 
-	err := conn.WriteJSON(map[string]string{
+	err := client.Send(map[string]string{
 		"type": "searching",
 	})
 	if err != nil {
-		log.Printf("Error sending searching message: %v\n", err)
+		client.Log().Warn("Error sending searching message", "err", err)
 	}
 	client.IsHost = false
-	client.Health = 5 // Reset health when searching for a match
+	client.IsSpectator = false
+	client.EliminatedRound = 0
+	client.Health = startingHealth // Reset health when searching for a match
 	addToMatchQueue(client)
 }
 
-func handleStartGame(client *Client, conn *websocket.Conn) {
-
-	clientsMutex.Lock()
-	defer clientsMutex.Unlock()
-
+func handleStartGame(client *Client, msg Message, conn *websocket.Conn) {
 	room := client.Room
-	if room == nil {
-		conn.WriteJSON(map[string]string{"error": "Not in any room"})
-		return
-	}
 
 	// roomClients, exists := clientsPerRoom[client.RoomCode]
 	// if !exists {
@@ -134,215 +241,431 @@ func handleStartGame(client *Client, conn *websocket.Conn) {
 	// }
 
 	if !client.IsHost {
-		conn.WriteJSON(map[string]string{"error": "Only the host can start the game"})
-		clientsMutex.Unlock()
+		client.Send(map[string]string{"error": "Only the host can start the game"})
 		return
 	}
 
 	if len(room.Players) < 2 {
-		conn.WriteJSON(map[string]string{"error": "Need at least 2 players to start"})
-		clientsMutex.Unlock()
+		client.Send(map[string]string{"error": "Need at least 2 players to start"})
 		return
 	}
 
 	startGame(room)
-	log.Printf("Host %s started the game in room %s\n", client.Name, room.RoomCode)
+	client.Log().Info("Host started the game")
 }
 
-func handleCancelFindMatch(client *Client, conn *websocket.Conn) {
+func handleCancelFindMatch(client *Client, msg Message, conn *websocket.Conn) {
 	removePlayerFromQueue(client)
 
-	client.Room = nil
 	client.IsHost = false
-	err := conn.WriteJSON(map[string]string{
+	err := client.Send(map[string]string{
 		"type": "cancelled",
 	})
 	if err != nil {
-		log.Printf("Error sending cancelled message: %v\n", err)
+		client.Log().Warn("Error sending cancelled message", "err", err)
 	}
-	log.Printf("Cancelled find match for %s\n", client.Name)
-	conn.WriteJSON(map[string]string{"type": "find_match_cancelled"})
+	client.Log().Info("Cancelled find match")
+	client.Send(map[string]string{"type": "find_match_cancelled"})
 }
 
-func handleLeaveRoom(client *Client, conn *websocket.Conn) {
-	if client.Room != nil {
-		removeClientFromRoom(client)
-		client.IsHost = false
-		client.Room = nil
-		conn.WriteJSON(map[string]string{"type": "left_room"})
-		log.Printf("%s left the room\n", client.Name)
-	}
+func handleLeaveRoom(client *Client, msg Message, conn *websocket.Conn) {
+	removeClientFromRoom(client)
+	client.IsHost = false
+	client.IsSpectator = false
+	client.Room = nil
+	client.Send(map[string]string{"type": "left_room"})
+	client.Log().Info("Left the room")
 }
 
 func handleAnswer(client *Client, msg Message, conn *websocket.Conn) {
-	if client.Health <= 0 {
-		conn.WriteJSON(map[string]string{
-			"error": "You've been eliminated and cannot answer anymore.",
-		})
+	room := client.Room
+	if room.Question == nil {
+		client.Send(map[string]string{"error": "No question in progress"})
+		return
+	}
+	if msg.QuestionID != room.Question.ID {
+		client.Send(map[string]string{"error": "Answer is for a different question"})
+		return
+	}
+	previous := room.PlayerAnswer(client)
+	if previous != nil && previous.Locked {
+		client.Send(map[string]string{"error": "You already answered this question"})
+		return
+	}
+	now := time.Now()
+	if now.After(room.AnswerDeadline(client)) {
+		client.Send(map[string]string{"error": "Time's up"})
+		return
+	}
+	if lock, locked := room.AnswerLocks[client.ID]; locked && now.Before(lock) {
+		client.Send(map[string]string{"error": "Your answers are locked for a few more seconds"})
 		return
 	}
 
-	// roomsMutex.RLock()
-	room := rooms[msg.Room]
-	// roomsMutex.RUnlock()
-
-	if room == nil {
-		conn.WriteJSON(map[string]string{"error": "Room not found"})
+	// Map the player's opaque option ID back to the one in quiz.json
+	answer, known := room.OptionIDs[client.ID][msg.Answer]
+	if !known {
+		client.Send(map[string]string{"error": "Unknown option"})
 		return
 	}
 
 	// Check correctness
 	correct := false
 	currentQuestion := room.Question
-	log.Printf("Current question: %+v", room.Question)
-
-	log.Printf("Player %s answered: %s", client.Name, msg.Answer)
-	log.Printf("Current question answer: %s", currentQuestion.Answer)
-
-	if msg.Answer == currentQuestion.Answer {
+	if answer == currentQuestion.Answer {
 		correct = true
 	}
 
-	room.AnswerLog = append(room.AnswerLog, &PlayerAnswer{
-		Client:     client,
-		Answer:     msg.Answer,
-		AnswerTime: msg.AnswerTime,
-		Correct:    correct,
+	// Answers are final unless the room lets players change them until lock-in
+	locked := !room.Settings.AllowAnswerChange
+	if previous == nil {
+		previous = &PlayerAnswer{Client: client}
+		room.AnswerLog = append(room.AnswerLog, previous)
+	}
+	previous.Answer = answer
+	previous.AnswerTime = now.UnixMilli() - room.QuestionStart // Measured by the server, not the client
+	previous.Correct = correct
+	previous.Locked = locked
+
+	client.Log().Debug("Answer received", "round", room.Round, "option", answer, "correct", correct, "timeMs", previous.AnswerTime)
+	room.logEvent("answer", map[string]interface{}{
+		"round":    room.Round,
+		"playerId": client.ID,
+		"optionId": answer,
+		"correct":  correct,
+		"timeMs":   previous.AnswerTime,
+		"locked":   locked,
+	})
+	client.Send(map[string]interface{}{
+		"type":       "answer_accepted",
+		"questionId": room.Question.ID,
+		"answer":     msg.Answer,
+		"locked":     locked,
 	})
 
-	log.Printf("Player %s answered: %s (correct: %t)", client.Name, msg.Answer, correct)
-	answerTimeoutMs := int64(30000) // 30 seconds
-	now := time.Now().UnixMilli()
-
-	if now-room.QuestionStart > answerTimeoutMs || len(room.AnswerLog) == len(room.Players) {
-
-		for _, player := range room.Players {
-			found := false
-			for _, ans := range room.AnswerLog {
-				if ans.Client == player {
-					found = true
-					break
-				}
-			}
-			if !found {
-				room.AnswerLog = append(room.AnswerLog, &PlayerAnswer{
-					Client:     player,
-					Answer:     "",
-					Correct:    false,
-					AnswerTime: 1<<63 - 1, // Max int64, so always slowest
-				})
-			}
-		}
-		if len(room.AnswerLog) == len(room.Players) {
-			result := room.EvaluateRoundResults()
-			if result.Winner == nil || result.Winner.Client == nil {
-				log.Printf("No winner found in this round")
-			}
-			log.Printf("Losers: %+v", result.Losers)
-			room.CalculateHealth(result.Winner, result.Losers)
-
-			loserNames := []string{}
-			for _, l := range result.Losers {
-				if l != nil && l.Client != nil {
-					loserNames = append(loserNames, l.Client.Name)
-				}
-			}
-			winnerName := ""
-			if result.Winner != nil && result.Winner.Client != nil {
-				winnerName = result.Winner.Client.Name
-			}
-
-			for _, p := range room.Players {
-				// p.connMutex.Lock()
-				err := p.Conn.WriteJSON(map[string]interface{}{
-					"type":   "round_result",
-					"winner": winnerName,
-					"losers": loserNames,
-				})
-				// p.connMutex.Unlock()
-				if err != nil {
-					log.Printf("Error sending round_result to %s: %v", p.ID, err)
-				}
+	if room.AllAnswersLocked() {
+		room.FinishRound()
+	}
+}
 
-			}
-			go func() {
-				time.Sleep(3 * time.Second)
-				room.AssignSabotagesToLosers(result)
-			}()
-		}
+func handleLockAnswer(client *Client, msg Message, conn *websocket.Conn) {
+	room := client.Room
+	if room.Question == nil {
+		client.Send(map[string]string{"error": "No question in progress"})
+		return
+	}
+	if msg.QuestionID != room.Question.ID {
+		client.Send(map[string]string{"error": "Answer is for a different question"})
+		return
+	}
+	answer := room.PlayerAnswer(client)
+	if answer == nil {
+		client.Send(map[string]string{"error": "Answer the question before locking in"})
+		return
+	}
+	answer.Locked = true
+	room.logEvent("answer_locked", map[string]interface{}{
+		"round":    room.Round,
+		"playerId": client.ID,
+	})
+	client.Send(map[string]interface{}{
+		"type":       "answer_accepted",
+		"questionId": room.Question.ID,
+		"locked":     true,
+	})
 
-		// }
+	if room.AllAnswersLocked() {
+		room.FinishRound()
 	}
 }
 
 func handleUseSabotage(winner *Client, msg Message, conn *websocket.Conn) {
 	room := winner.Room
-	if room == nil {
-		conn.WriteJSON(map[string]string{"error": "Room not found"})
-		return
-	}
 
 	// room.RoundMutex.Lock()
 	// defer room.RoundMutex.Unlock()
 
 	// Verify sabotage selection is in progress
-	if room.SabotageSelection == nil || room.SabotageSelection.WinnerID != winner.ID {
-		conn.WriteJSON(map[string]string{"error": "Not allowed to use sabotage"})
+	selection := room.SabotageSelection
+	if selection == nil || selection.WinnerID != winner.ID {
+		winner.Send(map[string]string{"error": "Not allowed to use sabotage"})
 		return
 	}
 
-	sabotageName := msg.Name
-	targetInfos := []map[string]string{}
+	// A single name applies the same sabotage to every pending target
+	picks := msg.Targets
+	if len(picks) == 0 && msg.Name != "" {
+		picks = make(map[string]string)
+		for targetID := range selection.Pending {
+			picks[targetID] = msg.Name
+		}
+	}
+	if len(picks) == 0 {
+		winner.Send(map[string]string{"error": "No sabotage chosen"})
+		return
+	}
 
-	// Apply to all losers (those in the choices map)
-	for playerID := range room.SabotageSelection.Choices {
-		targetName := "Unknown"
+	// Validate every pick before applying any of them
+	for targetID, sabotageName := range picks {
+		if !selection.Pending[targetID] {
+			winner.Send(map[string]string{"error": "Target is not waiting for a sabotage"})
+			return
+		}
+		if _, known := sabotageRegistry[sabotageName]; !known {
+			winner.Send(map[string]string{"error": "Unknown sabotage"})
+			return
+		}
+		if !slices.Contains(selection.Choices[targetID], sabotageName) {
+			winner.Send(map[string]string{"error": "Sabotage was not offered"})
+			return
+		}
+	}
+
+	// Group targets by sabotage so each one is announced once
+	targetInfos := make(map[string][]map[string]string)
+	for targetID, sabotageName := range picks {
+		delete(selection.Pending, targetID)
+
+		var target *Client
 		for _, client := range room.Players {
-			if client.ID == playerID {
-				targetName = client.Name
+			if client.ID == targetID {
+				target = client
 				break
 			}
 		}
+		if target == nil {
+			continue // Target left the room
+		}
 
-		targetInfos = append(targetInfos, map[string]string{
-			"id":   playerID,
-			"name": targetName,
+		if room.applySabotage(target, sabotageName, winner) {
+			targetInfos[sabotageName] = append(targetInfos[sabotageName], map[string]string{
+				"id":   target.ID,
+				"name": target.Name,
+			})
+		}
+		winner.Log().Info("Used sabotage", "sabotage", sabotageName, "target", targetID)
+	}
+
+	// Notify everyone in the room
+	for sabotageName, infos := range targetInfos {
+		room.Broadcast(map[string]interface{}{
+			"type":     "sabotage_applied",
+			"sabotage": sabotageName,
+			"usedBy":   winner.Name,
+			"targets":  infos,
 		})
+	}
 
-		// Store the sabotage effect
-		s := &Sabotage{
-			Name:     sabotageName,
-			Used:     true,
-			TargetID: playerID,
-			UsedByID: winner.ID,
+	// Partial picks keep the selection open for the remaining targets
+	if len(selection.Pending) > 0 {
+		pending := []string{}
+		for targetID := range selection.Pending {
+			pending = append(pending, targetID)
 		}
+		winner.Send(map[string]interface{}{
+			"type":     "sabotage_pending",
+			"pending":  pending,
+			"timeLeft": int(time.Until(selection.Deadline).Seconds()),
+			"deadline": selection.Deadline.UnixMilli(),
+		})
+		return
+	}
 
-		room.PlayerEffects[playerID] = append(room.PlayerEffects[playerID], s)
-		// Remove the used sabotage from the player's available sabotages
-		for i, sab := range room.AvailableSabotages[playerID] {
-			if sab.Name == sabotageName {
-				room.AvailableSabotages[playerID] = slices.Delete(room.AvailableSabotages[playerID], i, i+1)
-				break
-			}
+	room.SabotageSelection = nil
+	room.Schedule(3*time.Second, room.StartQuestion)
+}
+
+func handleListRooms(client *Client, msg Message, conn *websocket.Conn) {
+	err := client.Send(map[string]interface{}{
+		"type":  "room_list",
+		"rooms": listPublicRooms(),
+	})
+	if err != nil {
+		client.Log().Warn("Error sending room_list", "err", err)
+	}
+}
+
+func handleSpectateRoom(client *Client, msg Message, conn *websocket.Conn) {
+	removePlayerFromQueue(client)
+
+	if msg.Room == "" {
+		client.Send(map[string]string{"error": "Room code required to spectate"})
+		return
+	}
+	room, exists := rooms[msg.Room]
+	if !exists {
+		client.Send(map[string]string{"error": "Room does not exist"})
+		return
+	}
+	if room.Settings.Password != "" && msg.Password != room.Settings.Password {
+		client.Send(map[string]string{"error": "Incorrect room password"})
+		return
+	}
+
+	client.IsHost = false
+	client.IsSpectator = true
+	client.Room = room
+	room.Watchers = append(room.Watchers, client)
+	room.logEvent("spectator_joined", map[string]string{"playerId": client.ID, "name": client.Name})
+
+	client.Send(map[string]interface{}{
+		"type":     "spectating",
+		"roomCode": room.RoomCode,
+		"phase":    room.Phase,
+		"players":  room.PlayerInfo(),
+		"id":       client.ID,
+	})
+
+	client.Log().Info("Spectating room")
+	broadcastPlayerCount(room)
+}
+
+func handleRematch(client *Client, msg Message, conn *websocket.Conn) {
+	room := client.Room
+	if room.RematchVotes == nil {
+		client.Send(map[string]string{"error": "Rematch is only available after the game is over"})
+		return
+	}
+	if !slices.Contains(room.Players, client) {
+		client.Send(map[string]string{"error": "Only players can vote for a rematch"})
+		return
+	}
+
+	if !msg.Accept {
+		// Declining players leave the room, which recounts the votes
+		client.Log().Info("Declined a rematch")
+		handleLeaveRoom(client, msg, conn)
+		return
+	}
+	if shuttingDown {
+		client.Send(map[string]string{"error": "Server is shutting down"})
+		return
+	}
+	room.RematchVotes[client.ID] = true
+	client.Log().Info("Accepted a rematch")
+	room.CheckRematch()
+}
+
+func handleUseItem(client *Client, msg Message, conn *websocket.Conn) {
+	room := client.Room
+
+	item := msg.Name
+	idx := slices.Index(room.Items[client.ID], item)
+	if idx < 0 {
+		client.Send(map[string]string{"error": "You don't have that item"})
+		return
+	}
+
+	switch item {
+	case ItemShield, ItemReflect:
+		if armed := room.ArmedItems[client.ID]; armed != "" {
+			client.Send(map[string]string{"error": armed + " is already active"})
+			return
 		}
-		log.Printf("HANDLE player effect: %+v", room.PlayerEffects[playerID])
-		log.Printf("available sabotages: %+v", room.AvailableSabotages[playerID])
-		log.Printf("Applied sabotage %s from %s to %s", sabotageName, winner.ID, playerID)
+		room.ArmedItems[client.ID] = item
+
+	case ItemDebugger:
+		room.PlayerEffects[client.ID] = []*Sabotage{}
 	}
 
-	// Notify all players in the room
-	for _, c := range room.Players {
+	room.Items[client.ID] = slices.Delete(room.Items[client.ID], idx, idx+1)
+	client.Log().Info("Used item", "item", item)
 
-		c.Conn.WriteJSON(map[string]interface{}{
-			"type":     "sabotage_applied",
-			"sabotage": sabotageName,
-			"usedBy":   winner.Name,
-			"targets":  targetInfos,
+	client.Send(map[string]interface{}{
+		"type":           "item_used",
+		"item":           item,
+		"items":          room.Items[client.ID],
+		"armed":          room.ArmedItems[client.ID],
+		"active_effects": room.ActiveEffects(client.ID),
+	})
+	room.Broadcast(map[string]interface{}{
+		"type":   "item_activated",
+		"item":   item,
+		"player": client.Name,
+	})
+}
+
+func handleChooseReward(winner *Client, msg Message, conn *websocket.Conn) {
+	room := winner.Room
+	selection := room.RewardSelection
+	if selection == nil || selection.WinnerID != winner.ID {
+		winner.Send(map[string]string{"error": "Not allowed to choose a reward"})
+		return
+	}
+	if !slices.Contains(selection.Options, msg.Reward) {
+		winner.Send(map[string]string{"error": "Reward was not offered"})
+		return
+	}
+	if msg.Reward == RewardPowerUp && msg.PowerUp != PowerUpDoubleDamage && msg.PowerUp != PowerUpExtraTime {
+		winner.Send(map[string]string{"error": "Unknown power-up"})
+		return
+	}
+	room.RewardSelection = nil
+
+	switch msg.Reward {
+	case RewardSabotage:
+		room.OfferSabotages(selection.Result, selection.Deadline)
+		return
+
+	case RewardHeal:
+		winner.Health = min(winner.Health+1, startingHealth)
+		room.logHealth(winner, 1, "heal")
+		winner.Log().Debug("Healed", "health", winner.Health)
+		room.Broadcast(map[string]interface{}{
+			"type":    "player_update",
+			"players": room.PlayerInfo(),
 		})
+
+	case RewardPowerUp:
+		room.PowerUps[winner.ID] = append(room.PowerUps[winner.ID], msg.PowerUp)
+		winner.Log().Info("Banked power-up", "powerUp", msg.PowerUp)
+		winner.Send(map[string]interface{}{
+			"type":     "power_ups",
+			"powerUps": room.PowerUps[winner.ID],
+		})
+	}
+
+	room.Broadcast(map[string]interface{}{
+		"type":   "reward_chosen",
+		"winner": winner.Name,
+		"reward": msg.Reward,
+	})
+	room.Schedule(3*time.Second, room.StartQuestion)
+}
+
+func handleUsePowerUp(client *Client, msg Message, conn *websocket.Conn) {
+	room := client.Room
+	if room.Question == nil {
+		client.Send(map[string]string{"error": "Power-ups can only be used during a question"})
+		return
+	}
+	if room.PlayerAnswer(client) != nil {
+		client.Send(map[string]string{"error": "You already answered this question"})
+		return
+	}
+	idx := slices.Index(room.PowerUps[client.ID], msg.PowerUp)
+	if idx < 0 {
+		client.Send(map[string]string{"error": "You don't have that power-up"})
+		return
 	}
 
-	go func() {
-		time.Sleep(3 * time.Second)
-		room.StartQuestion()
-	}()
+	switch msg.PowerUp {
+	case PowerUpDoubleDamage:
+		if room.DoubleDamage[client.ID] {
+			client.Send(map[string]string{"error": "Double damage is already active"})
+			return
+		}
+		room.DoubleDamage[client.ID] = true
+	case PowerUpExtraTime:
+		room.TimeBonus[client.ID] += extraTimeBonus
+	}
+	room.PowerUps[client.ID] = slices.Delete(room.PowerUps[client.ID], idx, idx+1)
+	client.Log().Info("Used power-up", "powerUp", msg.PowerUp)
+
+	client.Send(map[string]interface{}{
+		"type":     "power_up_used",
+		"powerUp":  msg.PowerUp,
+		"powerUps": room.PowerUps[client.ID],
+		"timeLeft": int(time.Until(room.AnswerDeadline(client)).Seconds()),
+	})
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+)
+
+// Defensive items are earned by answer streaks and protect against sabotage
+const (
+	ItemShield   = "Shield"   // Blocks the next sabotage
+	ItemReflect  = "Reflect"  // Bounces the next player sabotage back to its sender
+	ItemDebugger = "Debugger" // Clears all active effects
+)
+
+var defensiveItems = []string{ItemShield, ItemReflect, ItemDebugger}
+
+const (
+	itemStreak   = 3 // Correct answers in a row needed to earn an item
+	maxItemsHeld = 3
+)
+
+// UpdateStreaks tracks consecutive correct answers and hands out a random
+// defensive item every itemStreak of them
+func (room *Room) UpdateStreaks(answers []*PlayerAnswer) {
+	for _, pa := range answers {
+		if pa == nil || pa.Client == nil {
+			continue
+		}
+		c := pa.Client
+		if !pa.Correct {
+			room.Streaks[c.ID] = 0
+			continue
+		}
+		room.Streaks[c.ID]++
+		if room.Streaks[c.ID]%itemStreak != 0 || len(room.Items[c.ID]) >= maxItemsHeld {
+			continue
+		}
+
+		item := defensiveItems[rand.Intn(len(defensiveItems))]
+		room.Items[c.ID] = append(room.Items[c.ID], item)
+		room.Log().Info("Earned item", "client", c.ID, "item", item, "streak", room.Streaks[c.ID])
+
+		c.Send(map[string]interface{}{
+			"type":   "item_earned",
+			"item":   item,
+			"streak": room.Streaks[c.ID],
+			"items":  room.Items[c.ID],
+		})
+	}
+}
+
+// resolveDefense runs target's armed item against an incoming sabotage.
+// It returns who the sabotage should land on, or nil if it was blocked.
+func (room *Room) resolveDefense(target *Client, name string, usedBy *Client) *Client {
+	switch room.ArmedItems[target.ID] {
+	case ItemShield:
+		delete(room.ArmedItems, target.ID)
+		room.Log().Info("Sabotage blocked by shield", "client", target.ID, "sabotage", name)
+		room.Broadcast(map[string]interface{}{
+			"type":     "sabotage_blocked",
+			"sabotage": name,
+			"target":   target.Name,
+			"item":     ItemShield,
+		})
+		return nil
+
+	case ItemReflect:
+		// Sabotages assigned by the system have nobody to bounce back to
+		if usedBy == nil || usedBy.IsSpectator || !slices.Contains(room.Players, usedBy) {
+			return target
+		}
+		delete(room.ArmedItems, target.ID)
+		room.Log().Info("Sabotage reflected", "client", target.ID, "sabotage", name, "sender", usedBy.ID)
+		room.Broadcast(map[string]interface{}{
+			"type":        "sabotage_reflected",
+			"sabotage":    name,
+			"target":      target.Name,
+			"reflectedTo": usedBy.Name,
+			"item":        ItemReflect,
+		})
+		return usedBy
+	}
+	return target
+}
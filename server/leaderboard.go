@@ -0,0 +1,169 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	BoardWins    = "wins"
+	BoardRating  = "rating"
+	BoardFastest = "fastest" // Average time of correct answers
+	BoardStreak  = "streak"  // Longest run of correct answers in one game
+
+	PeriodAll  = "all"
+	PeriodWeek = "week"
+)
+
+const (
+	startingRating = 1000.0
+	ratingK        = 32.0
+	// Players need this many correct answers to be ranked on the fastest board
+	minCorrectForFastest = 5
+	defaultPageSize      = 20
+	maxPageSize          = 100
+)
+
+var leaderboards = []string{BoardWins, BoardRating, BoardFastest, BoardStreak}
+
+type LeaderboardEntry struct {
+	Rank     int     `json:"rank"`
+	PlayerID string  `json:"playerId"`
+	Name     string  `json:"name"`
+	Value    float64 `json:"value"`
+	Games    int     `json:"games"`
+}
+
+type playerTotals struct {
+	name          string
+	games         int
+	wins          int
+	rating        float64
+	correct       int
+	correctTimeMs int64
+	longestStreak int
+}
+
+// periodStart is when matches start counting for period
+func periodStart(period string) time.Time {
+	if period == PeriodWeek {
+		return time.Now().AddDate(0, 0, -7)
+	}
+	return time.Time{}
+}
+
+// computeLeaderboard ranks players on board over matches, which must be
+// oldest first for ratings to come out right. Only players with a profile
+// are ranked, anonymous IDs change with every connection.
+func computeLeaderboard(board string, matches []*MatchRecord, ranked func(id string) bool) []LeaderboardEntry {
+	totals := make(map[string]*playerTotals)
+	get := func(id string) *playerTotals {
+		if totals[id] == nil {
+			totals[id] = &playerTotals{rating: startingRating}
+		}
+		return totals[id]
+	}
+
+	for _, match := range matches {
+		// Unfinished games still count towards answer stats, but
+		// placements from them would skew ratings
+		if match.Aborted == "" {
+			for _, p := range match.Players {
+				t := get(p.ID)
+				t.name = p.Name
+				t.games++
+			}
+			for _, id := range match.WinnerIDs {
+				get(id).wins++
+			}
+			updateRatings(match.Players, totals)
+		}
+
+		streaks := make(map[string]int)
+		for _, round := range match.RoundLog {
+			for _, a := range round.Answers {
+				t := get(a.PlayerID)
+				if !a.Correct {
+					streaks[a.PlayerID] = 0
+					continue
+				}
+				t.correct++
+				t.correctTimeMs += a.TimeMs
+				streaks[a.PlayerID]++
+				t.longestStreak = max(t.longestStreak, streaks[a.PlayerID])
+			}
+		}
+	}
+
+	entries := []LeaderboardEntry{}
+	for id, t := range totals {
+		if t.games == 0 || !ranked(id) {
+			continue
+		}
+		entry := LeaderboardEntry{PlayerID: id, Name: t.name, Games: t.games}
+		switch board {
+		case BoardWins:
+			entry.Value = float64(t.wins)
+		case BoardRating:
+			entry.Value = math.Round(t.rating)
+		case BoardFastest:
+			if t.correct < minCorrectForFastest {
+				continue
+			}
+			entry.Value = float64(t.correctTimeMs / int64(t.correct))
+		case BoardStreak:
+			entry.Value = float64(t.longestStreak)
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Value != b.Value {
+			if board == BoardFastest {
+				return a.Value < b.Value
+			}
+			return a.Value > b.Value
+		}
+		if a.Games != b.Games {
+			return a.Games < b.Games // Same result in fewer games ranks higher
+		}
+		return a.PlayerID < b.PlayerID
+	})
+	for i := range entries {
+		entries[i].Rank = i + 1
+		if i > 0 && entries[i].Value == entries[i-1].Value {
+			entries[i].Rank = entries[i-1].Rank
+		}
+	}
+	return entries
+}
+
+// updateRatings applies Elo to every pair of players in a game, scored by
+// placement and scaled so a game moves a rating by at most ratingK
+func updateRatings(players []GameResultPlayer, totals map[string]*playerTotals) {
+	if len(players) < 2 {
+		return
+	}
+	k := ratingK / float64(len(players)-1)
+	deltas := make(map[string]float64)
+	for _, a := range players {
+		for _, b := range players {
+			if a.ID == b.ID {
+				continue
+			}
+			expected := 1 / (1 + math.Pow(10, (totals[b.ID].rating-totals[a.ID].rating)/400))
+			score := 0.5
+			if a.Place < b.Place {
+				score = 1
+			} else if a.Place > b.Place {
+				score = 0
+			}
+			deltas[a.ID] += k * (score - expected)
+		}
+	}
+	for id, d := range deltas {
+		totals[id].rating += d
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// setupLogging installs the default logger. level is debug, info, warn or
+// error and format is text or json. Answers and other game content are only
+// logged at debug.
+func setupLogging(level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// Log returns a logger carrying the room's code, phase and game. Call with
+// clientsMutex held.
+func (room *Room) Log() *slog.Logger {
+	logger := slog.With("room", room.RoomCode, "phase", room.Phase)
+	if room.GameID != "" {
+		logger = logger.With("game", room.GameID)
+	}
+	return logger
+}
+
+// Log returns a logger carrying the client's ID, the action being handled
+// and its room. Call with clientsMutex held.
+func (c *Client) Log() *slog.Logger {
+	logger := slog.With("client", c.ID, "name", c.Name)
+	if c.Action != "" {
+		logger = logger.With("action", c.Action)
+	}
+	if c.Room != nil {
+		logger = logger.With("room", c.Room.RoomCode, "phase", c.Room.Phase)
+	}
+	return logger
+}
+
+// fatal logs msg at error level and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -2,19 +2,38 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"slices"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Message struct {
-	Action     string `json:"action"`
-	Name       string `json:"name"`
-	Room       string `json:"room,omitempty"`
-	Answer     string `json:"answer,omitempty"`
-	AnswerTime int64  `json:"answerTime,omitempty"`
+	Action     string            `json:"action"`
+	Name       string            `json:"name"`
+	Room       string            `json:"room,omitempty"`
+	Answer     string            `json:"answer,omitempty"`
+	QuestionID int               `json:"questionId,omitempty"`
+	Password   string            `json:"password,omitempty"`
+	Accept     bool              `json:"accept,omitempty"`
+	Targets    map[string]string `json:"targets,omitempty"` // Target client ID -> sabotage name
+	Reward     string            `json:"reward,omitempty"`
+	PowerUp    string            `json:"powerUp,omitempty"`
+	Settings   *RoomSettings     `json:"settings,omitempty"`
+	Token      string            `json:"token,omitempty"`    // Player token for identify, seat token for resume
+	PlayerID   string            `json:"playerId,omitempty"` // Profile to look up with get_profile
+	GameID     string            `json:"gameId,omitempty"`   // Game to replay
+	Speed      float64           `json:"speed,omitempty"`    // Replay speed, 0 to step manually
 }
 
 type Option struct {
@@ -29,6 +48,37 @@ type Question struct {
 	Answer  string   `json:"correctAnswer"`
 }
 
+// QuestionView is the client-facing part of a Question. It has no answer
+// field, so it can't leak the correct answer.
+type QuestionView struct {
+	ID      int      `json:"id"`
+	Text    string   `json:"question"`
+	Options []Option `json:"options"`
+}
+
+// QuestionMessage is the question sent to each player and spectator
+type QuestionMessage struct {
+	Type string `json:"type"`
+	QuestionView
+	Round         int                       `json:"round"`
+	Effect        []string                  `json:"effect,omitempty"`
+	ActiveEffects []ActiveEffect            `json:"active_effects"`
+	PlayerEffects map[string][]ActiveEffect `json:"player_effects,omitempty"` // Every player's effects, for spectators
+	TimeLimit     int                       `json:"timeLimit,omitempty"`
+	LockedFor     int                       `json:"lockedFor,omitempty"`
+	PowerUps      []string                  `json:"powerUps,omitempty"`
+	Spectator     bool                      `json:"spectator,omitempty"`
+}
+
+// AnswerReveal is one player's answer as shown in round_result
+type AnswerReveal struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Answer  string `json:"answer"`         // Option text, empty if they didn't answer
+	Time    *int64 `json:"time,omitempty"` // Milliseconds after the question was sent
+	Correct bool   `json:"correct"`
+}
+
 type Client struct {
 	ID        string
 	Conn      *websocket.Conn
@@ -37,6 +87,14 @@ type Client struct {
 	Room      *Room
 	IsHost    bool
 	Health    int
+	// Spectators receive game events but cannot answer. Eliminated
+	// players stay in Room.Players and become spectators too.
+	IsSpectator     bool
+	EliminatedRound int  // Round the player was eliminated in, 0 while alive
+	Identified      bool // ID is a stable profile ID rather than a per-connection one
+	Replay          *replaySession
+	QueuedAt        time.Time // When the client joined the match queue
+	Action          string    // Action being handled, for log context
 }
 
 type PlayerAnswer struct {
@@ -44,19 +102,49 @@ type PlayerAnswer struct {
 	Answer     string
 	AnswerTime int64
 	Correct    bool
+	Locked     bool
 }
 
 type Sabotage struct {
-	Name     string
-	Used     bool
-	TargetID string
-	UsedByID string
+	Name       string
+	Used       bool
+	TargetID   string
+	UsedByID   string
+	ReadyRound int // Round a used sabotage with a cooldown is available again
+	// Set on effects in Room.PlayerEffects
+	RoundsLeft int
+	Intensity  float64
+}
+
+// RoomSettings are chosen by the host when the room is created.
+// Private rooms are hidden from the room browser and may require a password.
+type RoomSettings struct {
+	Private  bool   `json:"private"`
+	Password string `json:"-"`
+	// TieBreak decides what happens when the last players are all
+	// eliminated in the same round
+	TieBreak string `json:"tieBreak"`
+	// EffectStacking decides what happens when a player is hit by an
+	// effect they already suffer from
+	EffectStacking string `json:"effectStacking"`
+	// SabotageTimeout is how many seconds the round winner has to pick
+	// sabotages, 0 uses the server default
+	SabotageTimeout int `json:"sabotageTimeout"`
+	// Rewards the round winner can choose from
+	Rewards []string `json:"rewards"`
+	// AllowAnswerChange lets players change their answer until they send
+	// lock_answer. Otherwise the first answer is final.
+	AllowAnswerChange bool `json:"allowAnswerChange"`
 }
 
 type Room struct {
 	Players []*Client
+	// Watchers joined by code as spectators and don't count toward maxPlayers
+	Watchers []*Client
 	// Host          *Client
 	RoomCode      string
+	Settings      RoomSettings
+	Phase         string
 	Question      *Question
 	QuestionStart int64
 	AnswerLog     []*PlayerAnswer
@@ -66,6 +154,27 @@ type Room struct {
 	AvailableSabotages map[string][]*Sabotage
 	PlayerEffects      map[string][]*Sabotage
 	SabotageSelection  *SabotageSelection
+	GameID             string
+	StartedAt          time.Time
+	LastActivity       time.Time
+	Timers             []*time.Timer // Pending round transitions, stopped when the game ends
+	Round              int
+	SuddenDeathRounds  int
+	Items              map[string][]string // Defensive items held per player
+	ArmedItems         map[string]string   // Shield or Reflect waiting for the next sabotage
+	Streaks            map[string]int      // Consecutive correct answers per player
+	QuestionDeck       []int               // Indices into questions not yet asked this game
+	RematchVotes       map[string]bool
+	RewardSelection    *RewardSelection
+	PowerUps           map[string][]string          // Banked power-ups per player
+	DoubleDamage       map[string]bool              // Players with double damage active this question
+	TimeBonus          map[string]time.Duration     // Extra (or less) answer time per player this question
+	AnswerLocks        map[string]time.Time         // Players can't answer before this time
+	OptionIDs          map[string]map[string]string // Per player, the opaque option IDs they were sent -> quiz.json option ID
+	Match              *MatchRecord                 // History of the game in progress, stored when it ends
+	Replay             *replayLog                   // Event log of the game in progress
+	// Seats of a restored game nobody has rejoined yet, keyed by resume token
+	Seats map[string]*SnapshotPlayer
 }
 
 type RoundResult struct {
@@ -75,12 +184,69 @@ type RoundResult struct {
 	Losers           []*PlayerAnswer
 }
 
+// RewardSelection tracks the round winner choosing their reward
+type RewardSelection struct {
+	WinnerID string
+	Options  []string
+	Result   *RoundResult
+	Deadline time.Time // Picking sabotages has to be done by then too
+}
+
+// SabotageSelection tracks the round winner picking a sabotage for each
+// loser. Choices and Pending are keyed by target client ID.
 type SabotageSelection struct {
 	WinnerID string
 	Choices  map[string][]string
 	Pending  map[string]bool
+	Deadline time.Time
 }
 
+const (
+	PhaseLobby    = "lobby"
+	PhasePlaying  = "playing"
+	PhaseGameOver = "game_over"
+	// Restored from a snapshot at startup, waiting for players to rejoin
+	PhaseResuming = "resuming"
+	// Deleted or shut down, nothing scheduled for it runs any more
+	PhaseClosed = "closed"
+)
+
+const (
+	TieBreakSuddenDeath = "sudden_death" // Tied players replay with 1 health
+	TieBreakShared      = "shared"       // Tied players share the victory
+)
+
+const (
+	RewardSabotage = "sabotage" // Sabotage the round's losers
+	RewardHeal     = "heal"     // Win back one health, up to startingHealth
+	RewardPowerUp  = "power_up" // Bank a power-up for later
+
+	PowerUpDoubleDamage = "double_damage" // Losers lose 2 health if you win the question
+	PowerUpExtraTime    = "extra_time"    // More time to answer the question
+)
+
+const (
+	StackingRefresh   = "refresh" // Restart the effect's duration
+	StackingIntensity = "stack"   // Add the intensities and restart the duration
+	StackingReject    = "reject"  // Keep the running effect and drop the new one
+)
+
+const (
+	maxPlayers     = 4
+	startingHealth = 5
+	// Sudden death falls back to a shared victory after this many rounds
+	maxSuddenDeathRounds = 3
+	questionTimeout      = 30 * time.Second
+	extraTimeBonus       = 10 * time.Second
+	// Bounds for a room's sabotage selection timeout, in seconds
+	minSabotageTimeout = 5
+	maxSabotageTimeout = 60
+)
+
+// Rewards a room can offer. Rooms only offer sabotage unless they opt in to
+// the others through their settings.
+var allRewards = []string{RewardSabotage, RewardHeal, RewardPowerUp}
+
 var (
 	// clientsPerRoom = make(map[string][]*Client)
 	clientsMutex sync.Mutex
@@ -93,112 +259,177 @@ var (
 	roomsMutex    sync.RWMutex
 	questions     []Question
 	questionMutex sync.Mutex
+	// Targets the winner hasn't picked a sabotage for by then get a random one
+	sabotageSelectionTimeout = 15 * time.Second
 )
 
 func main() {
-	err := LoadQuestions("quiz.json")
-	if err != nil {
-		log.Fatal("Failed to load questions:", err)
+	idleTimeout := flag.Duration("room-idle-timeout", 10*time.Minute, "close rooms that are empty or idle for this long")
+	flag.DurationVar(&sabotageSelectionTimeout, "sabotage-timeout", sabotageSelectionTimeout, "default time the round winner has to pick sabotages")
+	shutdownGrace := flag.Duration("shutdown-grace", 2*time.Minute, "on SIGTERM, how long running games get to finish before sockets are closed")
+	flag.StringVar(&snapshotFile, "snapshot-file", snapshotFile, "file running games are saved to on shutdown and restored from at startup, empty to disable")
+	flag.StringVar(&replayDir, "replay-dir", replayDir, "directory game event logs are written to, empty to disable")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
+	dbPath := flag.String("db", "bugbrawl.db", "BoltDB file match history is stored in, empty to disable")
+	flag.Parse()
+
+	if err := setupLogging(*logLevel, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-	// router := mux.NewRouter()
-
-	// // Enable CORS for development
-	// router.Use(func(next http.Handler) http.Handler {
-	// 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-	// 		w.Header().Set("Access-Control-Allow-Origin", "*")
-	// 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	// 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-	// 		if r.Method == "OPTIONS" {
-	// 			w.WriteHeader(http.StatusOK)
-	// 			return
-	// 		}
 
-	// 		next.ServeHTTP(w, r)
-	// 	})
-	// })
-
-	// router.HandleFunc("/ws", handleWS)
-
-	// api := router.PathPrefix("/api").Subrouter()
-	// api.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
-	// 	w.Header().Set("Content-Type", "text/plain")
-	// 	w.Write([]byte("Server running..."))
-	// }).Methods("GET")
-
-	http.HandleFunc("/ws", handleWS)
+	// Same bounds as a room's own sabotage timeout setting
+	clamped := max(minSabotageTimeout*time.Second, min(sabotageSelectionTimeout, maxSabotageTimeout*time.Second))
+	if clamped != sabotageSelectionTimeout {
+		slog.Warn("Sabotage timeout out of range, clamped", "requested", sabotageSelectionTimeout, "timeout", clamped)
+		sabotageSelectionTimeout = clamped
+	}
 
-	log.Println("Server running on http://0.0.0.0:8080")
-	err = http.ListenAndServe("0.0.0.0:8080", nil)
+	err := LoadQuestions("quiz.json")
+	if err != nil {
+		fatal("Failed to load questions", "err", err)
+	}
+	err = LoadSabotages("sabotages.json")
 	if err != nil {
-		log.Fatal(err)
+		fatal("Failed to load sabotages", "err", err)
+	}
+	if *dbPath != "" {
+		boltStore, err := openBoltStore(*dbPath)
+		if err != nil {
+			fatal("Failed to open match store", "err", err)
+		}
+		store = boltStore
+		profiles = boltStore
+		startMatchWriter()
+	}
+	if err := restoreRooms(); err != nil {
+		fatal("Failed to restore rooms", "err", err)
+	}
+	if err := closeUnfinishedMatches(); err != nil {
+		fatal("Failed to close unfinished matches", "err", err)
+	}
+	if err := loadQuestionStats(); err != nil {
+		fatal("Failed to load question stats", "err", err)
+	}
+	router := mux.NewRouter()
+
+	// Enable CORS for development
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	router.HandleFunc("/ws", handleWS)
+	router.Handle("/metrics", promhttp.Handler())
+
+	api := router.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("Server running..."))
+	}).Methods("GET")
+	api.HandleFunc("/rooms", handleListRoomsHTTP).Methods("GET")
+	api.HandleFunc("/profiles/{id}", handleGetProfileHTTP).Methods("GET")
+	api.HandleFunc("/leaderboards/{board}", handleLeaderboardHTTP).Methods("GET")
+	api.HandleFunc("/replays/{gameId}", handleGetReplayHTTP).Methods("GET")
+	api.HandleFunc("/questions/stats", handleQuestionStatsHTTP).Methods("GET")
+
+	startRoomJanitor(*idleTimeout)
+
+	srv := &http.Server{Addr: "0.0.0.0:8080", Handler: router}
+	go func() {
+		slog.Info("Server running", "addr", "http://0.0.0.0:8080")
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fatal("HTTP server failed", "err", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	slog.Info("Shutting down", "signal", sig.String())
+	shutdownServer(srv, *shutdownGrace)
+	if store != nil {
+		stopMatchWriter()
+		store.Close()
 	}
 }
 
 func handleWS(w http.ResponseWriter, r *http.Request) {
-	log.Println("New WebSocket connection attempt")
+	slog.Debug("New WebSocket connection attempt", "remote", r.RemoteAddr)
+	clientsMutex.Lock()
+	closing := shuttingDown
+	clientsMutex.Unlock()
+	if closing {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("WebSocket upgrade error:", err)
+		slog.Warn("WebSocket upgrade error", "remote", r.RemoteAddr, "err", err)
 		return
 	}
 	defer conn.Close()
-	log.Println("WebSocket connection established")
+	slog.Info("WebSocket connection established", "remote", r.RemoteAddr)
 
 	client := &Client{Conn: conn, ID: generateClientID()}
+	clientsMutex.Lock()
+	connections[client] = true
+	clientsMutex.Unlock()
+	activeConnections.Inc()
 
+	dropped := false
 	for {
 		_, msgBytes, err := conn.ReadMessage()
 		if err != nil {
-			log.Println("Failed to read message:", err)
+			slog.Info("Connection closed", "client", client.ID, "err", err)
+			dropped = websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway)
 			break
 		}
 
-		log.Printf("Received raw message: %s", string(msgBytes))
+		slog.Debug("Received message", "client", client.ID, "raw", string(msgBytes))
 
 		var msg Message
 		if err := json.Unmarshal(msgBytes, &msg); err != nil {
-			log.Println("Invalid JSON:", err)
-			conn.WriteJSON(map[string]string{"error": "Invalid JSON"})
+			slog.Warn("Invalid JSON", "client", client.ID, "err", err)
+			client.Send(map[string]string{"error": "Invalid JSON"})
 			continue
 		}
 
-		log.Printf("Parsed message: %+v", msg)
-		if msg.Name != "" {
+		// Name doubles as the sabotage name in use_sabotage, so only
+		// lobby actions may rename the player
+		if msg.Name != "" && slices.Contains([]string{"create", "join", "find_match", "spectate", "identify"}, msg.Action) {
 			client.Name = msg.Name
 		}
 
 		clientsMutex.Lock()
+		if client.Room != nil {
+			client.Room.LastActivity = time.Now()
+		}
 
-		switch msg.Action {
-		case "create":
-			handleCreateRoom(client, conn)
-
-		case "join":
-			handleJoinRoom(client, conn, msg)
-
-		case "find_match":
-			handleFindMatch(client, conn)
-
-		case "start_game":
-
-			handleStartGame(client, conn)
-
-		case "cancel_find_match":
-			handleCancelFindMatch(client, conn)
-
-		case "leave_room":
-			handleLeaveRoom(client, conn)
-
-		case "player_answer":
-			handleAnswer(client, msg, conn)
-
-		case "use_sabotage":
-			handleUseSabotage(client, msg, conn)
-
-		default:
-			conn.WriteJSON(map[string]string{"error": "Invalid action"})
+		client.Action = msg.Action
+		spec, known := actions[msg.Action]
+		if !known {
+			client.Log().Warn("Unknown action")
+			client.Send(map[string]string{"error": "Invalid action"})
+		} else if reason := guardAction(client, spec); reason != "" {
+			client.Log().Info("Action refused", "reason", reason)
+			client.Send(map[string]string{"error": reason})
+		} else {
+			client.Log().Debug("Handling action")
+			spec.handler(client, msg, conn)
 		}
+		client.Action = ""
 
 		clientsMutex.Unlock()
 	}
@@ -206,19 +437,25 @@ func handleWS(w http.ResponseWriter, r *http.Request) {
 	// Clean up when client disconnects
 	clientsMutex.Lock()
 	defer clientsMutex.Unlock()
+	delete(connections, client)
+	stopReplay(client)
+	activeConnections.Dec()
+	if dropped && !shuttingDown {
+		droppedClients.WithLabelValues("connection_lost").Inc()
+	}
 
 	// Remove from match queue if they were searching
 	queueMutex.Lock()
 	for i, queuedClient := range matchQueue {
 		if queuedClient == client {
 			matchQueue = append(matchQueue[:i], matchQueue[i+1:]...)
-			log.Printf("Removed %s (%s) from match queue\n", client.Name, client.ID)
+			client.Log().Info("Removed from match queue")
 			break
 		}
 	}
 	queueMutex.Unlock()
 
-	if client.Room.RoomCode != "" {
+	if client.Room != nil {
 		removeClientFromRoom(client)
 	}
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	activeConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bugbrawl_connections_active",
+		Help: "Open WebSocket connections.",
+	})
+	queueWaitSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "bugbrawl_match_queue_wait_seconds",
+		Help:    "Time players spent in the match queue before being matched.",
+		Buckets: []float64{1, 2, 5, 10, 20, 30, 60, 120, 300},
+	})
+	gamesStarted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "bugbrawl_games_started_total",
+		Help: "Games started, including rematches.",
+	})
+	gamesFinished = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "bugbrawl_games_finished_total",
+		Help: "Games that reached game over.",
+	})
+	roundDurationSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "bugbrawl_round_duration_seconds",
+		Help:    "Time from a question being sent to the round ending.",
+		Buckets: []float64{2, 5, 10, 15, 20, 25, 30, 40, 60},
+	})
+	answerLatencySeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "bugbrawl_answer_latency_seconds",
+		Help:    "Server-measured time players took to answer.",
+		Buckets: []float64{0.5, 1, 2, 3, 5, 8, 12, 20, 30, 40},
+	})
+	sabotagesApplied = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bugbrawl_sabotages_applied_total",
+		Help: "Sabotages that landed on a player, by sabotage.",
+	}, []string{"sabotage"})
+	writeErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "bugbrawl_write_errors_total",
+		Help: "Failed writes to WebSocket clients.",
+	})
+	droppedClients = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bugbrawl_dropped_clients_total",
+		Help: "Clients whose connection was lost rather than closed.",
+	}, []string{"reason"})
+)
+
+var (
+	roomsDesc = prometheus.NewDesc("bugbrawl_rooms", "Rooms by phase.", []string{"phase"}, nil)
+	queueDesc = prometheus.NewDesc("bugbrawl_match_queue_length", "Players waiting in the match queue.", nil, nil)
+)
+
+// stateCollector reads room and queue state when metrics are scraped
+type stateCollector struct{}
+
+func (stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- roomsDesc
+	ch <- queueDesc
+}
+
+func (stateCollector) Collect(ch chan<- prometheus.Metric) {
+	phases := map[string]int{PhaseLobby: 0, PhasePlaying: 0, PhaseGameOver: 0, PhaseResuming: 0}
+	clientsMutex.Lock() // Phases change under clientsMutex
+	roomsMutex.RLock()
+	for _, room := range rooms {
+		phases[room.Phase]++
+	}
+	roomsMutex.RUnlock()
+	clientsMutex.Unlock()
+	for phase, count := range phases {
+		ch <- prometheus.MustNewConstMetric(roomsDesc, prometheus.GaugeValue, float64(count), phase)
+	}
+
+	queueMutex.Lock()
+	queued := len(matchQueue)
+	queueMutex.Unlock()
+	ch <- prometheus.MustNewConstMetric(queueDesc, prometheus.GaugeValue, float64(queued))
+}
+
+func init() {
+	prometheus.MustRegister(stateCollector{})
+}
+
+func observeSince(h prometheus.Histogram, start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
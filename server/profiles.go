@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+var errProfileNotFound = errors.New("profile not found")
+
+// Profile is a player that identified with a token. Its ID replaces the
+// per-connection client ID, so match history can be tied back to it.
+type Profile struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// ProfileStore keeps profiles. The token is a secret only the player knows,
+// the profile ID is public.
+type ProfileStore interface {
+	SaveProfile(token string, profile *Profile) error
+	Profile(id string) (*Profile, error)
+	ProfileByToken(token string) (*Profile, error)
+}
+
+// profiles is nil when storage is disabled
+var profiles ProfileStore
+
+type MissedQuestion struct {
+	QuestionID int    `json:"questionId"`
+	Question   string `json:"question"`
+	Misses     int    `json:"misses"`
+}
+
+// ProfileStats are lifetime stats worked out from stored match history
+type ProfileStats struct {
+	GamesPlayed       int              `json:"gamesPlayed"`
+	Wins              int              `json:"wins"`
+	Accuracy          float64          `json:"accuracy"`        // Share of questions answered correctly, 0-1
+	AverageAnswerMs   int64            `json:"averageAnswerMs"` // Over questions the player answered
+	FavouriteSabotage string           `json:"favouriteSabotage,omitempty"`
+	MostMissed        []MissedQuestion `json:"mostMissed"`
+}
+
+const mostMissedCount = 3
+
+func computeProfileStats(playerID string, matches []*MatchRecord) ProfileStats {
+	stats := ProfileStats{MostMissed: []MissedQuestion{}}
+	questionsSeen, correct := 0, 0
+	answered, answerTime := 0, int64(0)
+	sabotageUses := make(map[string]int)
+	missed := make(map[int]*MissedQuestion)
+
+	for _, match := range matches {
+		played := false
+		for _, p := range match.Players {
+			if p.ID == playerID {
+				played = true
+			}
+		}
+		if !played {
+			continue
+		}
+		if match.Aborted == "" {
+			stats.GamesPlayed++
+			for _, id := range match.WinnerIDs {
+				if id == playerID {
+					stats.Wins++
+				}
+			}
+		}
+
+		for _, round := range match.RoundLog {
+			for _, a := range round.Answers {
+				if a.PlayerID != playerID {
+					continue
+				}
+				questionsSeen++
+				if a.OptionID != "" {
+					answered++
+					answerTime += a.TimeMs
+				}
+				if a.Correct {
+					correct++
+					continue
+				}
+				if missed[round.QuestionID] == nil {
+					missed[round.QuestionID] = &MissedQuestion{QuestionID: round.QuestionID, Question: round.Question}
+				}
+				missed[round.QuestionID].Misses++
+			}
+		}
+		for _, s := range match.Sabotages {
+			if s.UsedByID == playerID {
+				sabotageUses[s.Name]++
+			}
+		}
+	}
+
+	if questionsSeen > 0 {
+		stats.Accuracy = float64(correct) / float64(questionsSeen)
+	}
+	if answered > 0 {
+		stats.AverageAnswerMs = answerTime / int64(answered)
+	}
+	for name, uses := range sabotageUses {
+		best := sabotageUses[stats.FavouriteSabotage]
+		if uses > best || (uses == best && name < stats.FavouriteSabotage) {
+			stats.FavouriteSabotage = name
+		}
+	}
+	for _, m := range missed {
+		stats.MostMissed = append(stats.MostMissed, *m)
+	}
+	sort.Slice(stats.MostMissed, func(i, j int) bool {
+		if stats.MostMissed[i].Misses != stats.MostMissed[j].Misses {
+			return stats.MostMissed[i].Misses > stats.MostMissed[j].Misses
+		}
+		return stats.MostMissed[i].QuestionID < stats.MostMissed[j].QuestionID
+	})
+	if len(stats.MostMissed) > mostMissedCount {
+		stats.MostMissed = stats.MostMissed[:mostMissedCount]
+	}
+	return stats
+}
+
+// loadProfile returns the profile with id and its lifetime stats
+func loadProfile(id string) (*Profile, ProfileStats, error) {
+	profile, err := profiles.Profile(id)
+	if err != nil {
+		return nil, ProfileStats{}, err
+	}
+	matches, err := store.Matches(time.Time{})
+	if err != nil {
+		return nil, ProfileStats{}, err
+	}
+	return profile, computeProfileStats(id, matches), nil
+}
+
+// handleIdentify links the connection to a profile. Without a token a new
+// profile is created and its token sent back for the client to keep. The
+// profile is loaded and saved without holding clientsMutex.
+func handleIdentify(client *Client, msg Message, conn *websocket.Conn) {
+	if profiles == nil {
+		client.Send(map[string]string{"error": "Profiles are disabled on this server"})
+		return
+	}
+	if client.Identified {
+		client.Send(map[string]string{"error": "Already identified"})
+		return
+	}
+
+	logger := client.Log()
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("Recovered identifying", "panic", r)
+			}
+		}()
+
+		token := msg.Token
+		var profile *Profile
+		if token == "" {
+			token = uuid.NewString()
+			profile = &Profile{ID: uuid.NewString(), CreatedAt: time.Now()}
+		} else {
+			var err error
+			profile, err = profiles.ProfileByToken(token)
+			if err == errProfileNotFound {
+				client.Send(map[string]string{"error": "Unknown player token"})
+				return
+			}
+			if err != nil {
+				logger.Error("Error loading profile", "err", err)
+				client.Send(map[string]string{"error": "Could not load profile"})
+				return
+			}
+		}
+		if msg.Name != "" {
+			profile.Name = msg.Name
+		}
+		profile.LastSeen = time.Now()
+		if err := profiles.SaveProfile(token, profile); err != nil {
+			logger.Error("Error saving profile", "profile", profile.ID, "err", err)
+			client.Send(map[string]string{"error": "Could not save profile"})
+			return
+		}
+
+		clientsMutex.Lock()
+		defer clientsMutex.Unlock()
+		// The client may have moved on while the profile loaded
+		if client.Identified {
+			client.Send(map[string]string{"error": "Already identified"})
+			return
+		}
+		if client.Room != nil {
+			client.Send(map[string]string{"error": "Leave your current room first"})
+			return
+		}
+		// Client IDs key a lot of room state, so one profile gets one connection
+		for c := range connections {
+			if c != client && c.ID == profile.ID {
+				client.Send(map[string]string{"error": "This profile is already connected"})
+				return
+			}
+		}
+
+		if profile.Name != "" {
+			client.Name = profile.Name
+		}
+		client.ID = profile.ID
+		client.Identified = true
+		client.Send(map[string]interface{}{
+			"type":    "identified",
+			"token":   token,
+			"profile": profile,
+		})
+		client.Log().Info("Identified with profile")
+	}()
+}
+
+// handleGetProfile sends the profile and stats for msg.PlayerID, or the
+// client's own profile if it's empty. Stats come from every stored match, so
+// they're loaded without holding clientsMutex.
+func handleGetProfile(client *Client, msg Message, conn *websocket.Conn) {
+	if profiles == nil {
+		client.Send(map[string]string{"error": "Profiles are disabled on this server"})
+		return
+	}
+	id := msg.PlayerID
+	if id == "" {
+		if !client.Identified {
+			client.Send(map[string]string{"error": "Identify first to see your profile"})
+			return
+		}
+		id = client.ID
+	}
+
+	logger := client.Log()
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("Recovered loading profile", "profile", id, "panic", r)
+			}
+		}()
+		profile, stats, err := loadProfile(id)
+		if err == errProfileNotFound {
+			client.Send(map[string]string{"error": "Profile not found"})
+			return
+		}
+		if err != nil {
+			logger.Error("Error loading profile", "profile", id, "err", err)
+			client.Send(map[string]string{"error": "Could not load profile"})
+			return
+		}
+		client.Send(map[string]interface{}{
+			"type":    "profile",
+			"profile": profile,
+			"stats":   stats,
+		})
+	}()
+}
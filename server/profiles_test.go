@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIdentify(t *testing.T) {
+	db, err := openBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	profiles = db
+	t.Cleanup(func() { profiles = nil })
+
+	first, firstReceived := newTestClient(t, "first", 5, 0)
+	second, secondReceived := newTestClient(t, "second", 5, 0)
+	clientsMutex.Lock()
+	connections[first] = true
+	connections[second] = true
+	clientsMutex.Unlock()
+	t.Cleanup(func() {
+		clientsMutex.Lock()
+		delete(connections, first)
+		delete(connections, second)
+		clientsMutex.Unlock()
+	})
+
+	clientsMutex.Lock()
+	handleIdentify(first, Message{Name: "alice"}, first.Conn)
+	clientsMutex.Unlock()
+	identified := waitFor(t, firstReceived, "identified")
+	token := identified["token"].(string)
+
+	clientsMutex.Lock()
+	if !first.Identified || first.Name != "alice" || first.ID == "first" {
+		t.Errorf("client %s named %s, identified %t, want the new profile", first.ID, first.Name, first.Identified)
+	}
+	// The same profile can't be used from a second connection
+	handleIdentify(second, Message{Token: token}, second.Conn)
+	clientsMutex.Unlock()
+	if msg := <-secondReceived; msg["error"] != "This profile is already connected" {
+		t.Errorf("got %v, want the already connected error", msg)
+	}
+
+	clientsMutex.Lock()
+	handleIdentify(second, Message{Token: "not-a-token"}, second.Conn)
+	clientsMutex.Unlock()
+	if msg := <-secondReceived; msg["error"] != "Unknown player token" {
+		t.Errorf("got %v, want the unknown token error", msg)
+	}
+}
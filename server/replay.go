@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Directory every game's event log is written to, empty to disable
+var replayDir = "replays"
+
+var errReplayNotFound = errors.New("replay not found")
+
+const maxReplaySpeed = 16
+
+// ReplayEvent is one line of a game's event log
+type ReplayEvent struct {
+	Seq      int             `json:"seq"`
+	Time     time.Time       `json:"time"`     // Server time of the event
+	OffsetMs int64           `json:"offsetMs"` // Since the game started
+	Type     string          `json:"type"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// replayLog appends a room's events to its game's file
+type replayLog struct {
+	file  *os.File
+	seq   int
+	start time.Time
+}
+
+func replayPath(gameID string) (string, error) {
+	// Game IDs are UUIDs, anything else could escape replayDir
+	if _, err := uuid.Parse(gameID); err != nil || replayDir == "" {
+		return "", errReplayNotFound
+	}
+	return filepath.Join(replayDir, gameID+".jsonl"), nil
+}
+
+// openReplay starts the event log for the game that's starting in room
+func (room *Room) openReplay() {
+	room.closeReplay("restarted")
+	path, err := replayPath(room.GameID)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(replayDir, 0755); err != nil {
+		slog.Error("Error creating replay directory", "dir", replayDir, "err", err)
+		return
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		room.Log().Error("Error opening replay", "err", err)
+		return
+	}
+	room.Replay = &replayLog{file: file, start: room.StartedAt}
+}
+
+// logEvent appends an event to the game's replay, if one is being written
+func (room *Room) logEvent(eventType string, data interface{}) {
+	if room.Replay == nil {
+		return
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		room.Log().Error("Error encoding replay event", "event", eventType, "err", err)
+		return
+	}
+	now := time.Now()
+	room.Replay.seq++
+	event := ReplayEvent{
+		Seq:      room.Replay.seq,
+		Time:     now,
+		OffsetMs: now.Sub(room.Replay.start).Milliseconds(),
+		Type:     eventType,
+		Data:     raw,
+	}
+	if err := json.NewEncoder(room.Replay.file).Encode(event); err != nil {
+		room.Log().Error("Error writing replay", "err", err)
+	}
+}
+
+// closeReplay ends the game's replay. A reason is logged as a final event
+// when the game didn't end normally.
+func (room *Room) closeReplay(reason string) {
+	if room.Replay == nil {
+		return
+	}
+	if reason != "" {
+		room.logEvent("game_aborted", map[string]interface{}{"reason": reason})
+	}
+	if err := room.Replay.file.Close(); err != nil {
+		room.Log().Warn("Error closing replay", "err", err)
+	}
+	room.Replay = nil
+}
+
+// replayInProgress reports whether gameID is still being played. Its log has
+// the answer to the current question, so it isn't served until the game
+// ends. Call with clientsMutex held.
+func replayInProgress(gameID string) bool {
+	roomsMutex.RLock()
+	defer roomsMutex.RUnlock()
+	for _, room := range rooms {
+		if room.Replay != nil && room.GameID == gameID {
+			return true
+		}
+	}
+	return false
+}
+
+func loadReplay(gameID string) ([]ReplayEvent, error) {
+	path, err := replayPath(gameID)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errReplayNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	events := []ReplayEvent{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event ReplayEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+// replaySession is a client stepping through a past game. With a speed of 0
+// the client asks for each event with replay_step, otherwise events are
+// sent on their original schedule divided by speed.
+type replaySession struct {
+	gameID string
+	events []ReplayEvent
+	speed  float64
+	next   int
+	stop   chan struct{}
+}
+
+func (s *replaySession) eventMessage(event ReplayEvent) map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "replay_event",
+		"gameId":   s.gameID,
+		"seq":      event.Seq,
+		"offsetMs": event.OffsetMs,
+		"event":    event.Type,
+		"data":     event.Data,
+	}
+}
+
+// play sends the events in real time. It runs without clientsMutex and only
+// writes through client.Send.
+func (s *replaySession) play(client *Client) {
+	for s.next < len(s.events) {
+		event := s.events[s.next]
+		delay := time.Duration(0)
+		if s.next > 0 {
+			gap := event.OffsetMs - s.events[s.next-1].OffsetMs
+			delay = time.Duration(float64(gap)/s.speed) * time.Millisecond
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(delay):
+		}
+		if err := client.Send(s.eventMessage(event)); err != nil {
+			return
+		}
+		s.next++
+	}
+
+	clientsMutex.Lock()
+	if client.Replay == s {
+		client.Replay = nil
+	}
+	clientsMutex.Unlock()
+	client.Send(map[string]interface{}{"type": "replay_end", "gameId": s.gameID})
+}
+
+// stopReplay ends the client's replay if it's watching one
+func stopReplay(client *Client) {
+	if client.Replay == nil {
+		return
+	}
+	close(client.Replay.stop)
+	client.Replay = nil
+}
+
+func handleReplay(client *Client, msg Message, conn *websocket.Conn) {
+	if msg.Speed < 0 || msg.Speed > maxReplaySpeed {
+		client.Send(map[string]string{"error": "Replay speed must be between 0 and 16"})
+		return
+	}
+	if replayInProgress(msg.GameID) {
+		client.Send(map[string]string{"error": "That game is still being played"})
+		return
+	}
+	events, err := loadReplay(msg.GameID)
+	if err == errReplayNotFound {
+		client.Send(map[string]string{"error": "Replay not found"})
+		return
+	}
+	if err != nil {
+		client.Log().Error("Error loading replay", "game", msg.GameID, "err", err)
+		client.Send(map[string]string{"error": "Could not load replay"})
+		return
+	}
+
+	removePlayerFromQueue(client)
+	session := &replaySession{
+		gameID: msg.GameID,
+		events: events,
+		speed:  msg.Speed,
+		stop:   make(chan struct{}),
+	}
+	client.Replay = session
+	client.Send(map[string]interface{}{
+		"type":   "replay_started",
+		"gameId": msg.GameID,
+		"events": len(events),
+		"speed":  msg.Speed,
+	})
+	if session.speed > 0 {
+		go session.play(client)
+	}
+}
+
+func handleReplayStep(client *Client, msg Message, conn *websocket.Conn) {
+	session := client.Replay
+	if session == nil {
+		client.Send(map[string]string{"error": "No replay in progress"})
+		return
+	}
+	if session.speed > 0 {
+		client.Send(map[string]string{"error": "Replay is playing automatically"})
+		return
+	}
+	if session.next < len(session.events) {
+		client.Send(session.eventMessage(session.events[session.next]))
+		session.next++
+	}
+	if session.next == len(session.events) {
+		stopReplay(client)
+		client.Send(map[string]interface{}{"type": "replay_end", "gameId": session.gameID})
+	}
+}
+
+func handleReplayStop(client *Client, msg Message, conn *websocket.Conn) {
+	if client.Replay == nil {
+		client.Send(map[string]string{"error": "No replay in progress"})
+		return
+	}
+	gameID := client.Replay.gameID
+	stopReplay(client)
+	client.Send(map[string]interface{}{"type": "replay_stopped", "gameId": gameID})
+}
+
+func (room *Room) logHealth(c *Client, change int, reason string) {
+	room.logEvent("health", map[string]interface{}{
+		"playerId": c.ID,
+		"change":   change,
+		"health":   c.Health,
+		"reason":   reason,
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import "time"
+
+type GameResultPlayer struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Health int    `json:"health"`
+	Place  int    `json:"place"`
+}
+
+// GameResult is the record kept for every finished game
+type GameResult struct {
+	GameID    string             `json:"gameId"`
+	RoomCode  string             `json:"roomCode"`
+	StartedAt time.Time          `json:"startedAt"`
+	EndedAt   time.Time          `json:"endedAt"`
+	Rounds    int                `json:"rounds"`
+	WinnerIDs []string           `json:"winnerIds"`
+	Players   []GameResultPlayer `json:"players"`
+}
+
+// resultPlayers turns final placements into the players of a GameResult
+func resultPlayers(placements []Placement) []GameResultPlayer {
+	players := []GameResultPlayer{}
+	for _, p := range placements {
+		players = append(players, GameResultPlayer{
+			ID:     p.ID,
+			Name:   p.Name,
+			Health: p.Health,
+			Place:  p.Place,
+		})
+	}
+	return players
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"time"
+)
+
+// OfferRewards asks the round winner to pick between the rewards the room
+// allows. Rooms that only allow sabotage go straight to OfferSabotages, and
+// a winner who doesn't decide in time sabotages the losers at random.
+func (room *Room) OfferRewards(result *RoundResult) {
+	winner := result.Winner.Client
+
+	options := []string{}
+	for _, reward := range room.Settings.Rewards {
+		if reward == RewardHeal && winner.Health >= startingHealth {
+			continue // Nothing to heal
+		}
+		options = append(options, reward)
+	}
+	if len(options) == 0 {
+		room.StartQuestion()
+		return
+	}
+
+	// One deadline covers both the reward and the sabotages that may follow
+	timeout := room.SabotageTimeout()
+	deadline := time.Now().Add(timeout)
+	room.waitForWinner(result, deadline)
+	if len(options) == 1 && options[0] == RewardSabotage {
+		room.OfferSabotages(result, deadline)
+		return
+	}
+
+	selection := &RewardSelection{
+		WinnerID: winner.ID,
+		Options:  options,
+		Result:   result,
+		Deadline: deadline,
+	}
+	room.RewardSelection = selection
+
+	err := winner.Send(map[string]interface{}{
+		"type":     "choose_reward",
+		"options":  options,
+		"powerUps": []string{PowerUpDoubleDamage, PowerUpExtraTime},
+		"timeLeft": int(timeout.Seconds()),
+		"deadline": deadline.UnixMilli(),
+	})
+	if err != nil {
+		room.Log().Warn("Error sending reward choices to winner", "err", err)
+	}
+
+	room.Schedule(timeout, func() {
+		if room.RewardSelection != selection {
+			return // Winner already chose
+		}
+		room.RewardSelection = nil
+		room.Log().Info("Reward selection timed out")
+		RandomSabotage(result.Losers, room)
+	})
+}
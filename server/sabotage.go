@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"slices"
+	"time"
+)
+
+// SabotageDef describes a sabotage type from sabotages.json. Clients receive
+// the whole registry when a game starts, so new sabotages only need a new
+// entry in the data file.
+type SabotageDef struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Duration    int     `json:"duration"` // Rounds the effect lasts
+	Intensity   float64 `json:"intensity"`
+	Cooldown    int     `json:"cooldown"` // Rounds before it can hit the same player again, 0 = once per game
+	Rarity      string  `json:"rarity"`
+	// Enforce names the server-side behaviour of the sabotage. Sabotages
+	// without one are purely cosmetic and rely on the client.
+	Enforce string `json:"enforce,omitempty"`
+}
+
+// Server-enforced sabotage behaviours, scaled by the effect's intensity
+const (
+	EnforceTimeCrunch  = "time_crunch"  // Less time to answer
+	EnforceAnswerLock  = "answer_lock"  // Answers are refused for the first seconds
+	EnforceLag         = "lag"          // The question is delivered late
+	EnforceHideOptions = "hide_options" // Option texts are hidden
+)
+
+const (
+	timeCrunchPerIntensity = 10 * time.Second
+	answerLockPerIntensity = 3 * time.Second
+	lagPerIntensity        = 2 * time.Second
+	minAnswerTime          = 5 * time.Second // Time crunch never leaves less than this
+)
+
+// ActiveEffect is a sabotage currently affecting a player, as sent to clients
+type ActiveEffect struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	RoundsLeft int     `json:"roundsLeft"`
+	Intensity  float64 `json:"intensity"`
+	UsedBy     string  `json:"usedBy"`
+}
+
+// Relative odds of each rarity being picked by RandomSabotage
+var rarityWeights = map[string]int{
+	"common":   6,
+	"uncommon": 3,
+	"rare":     1,
+}
+
+var (
+	sabotageRegistry = map[string]*SabotageDef{}
+	sabotageOrder    []string // Registry IDs in file order
+)
+
+// LoadSabotages reads the sabotage registry from filename
+func LoadSabotages(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var defs []*SabotageDef
+	if err := json.NewDecoder(file).Decode(&defs); err != nil {
+		return err
+	}
+
+	registry := make(map[string]*SabotageDef, len(defs))
+	order := make([]string, 0, len(defs))
+	for _, def := range defs {
+		if def.ID == "" {
+			return fmt.Errorf("sabotage without id in %s", filename)
+		}
+		if _, exists := registry[def.ID]; exists {
+			return fmt.Errorf("duplicate sabotage %q in %s", def.ID, filename)
+		}
+		if _, ok := rarityWeights[def.Rarity]; !ok {
+			return fmt.Errorf("sabotage %q has unknown rarity %q", def.ID, def.Rarity)
+		}
+		switch def.Enforce {
+		case "", EnforceTimeCrunch, EnforceAnswerLock, EnforceLag, EnforceHideOptions:
+		default:
+			return fmt.Errorf("sabotage %q has unknown enforce %q", def.ID, def.Enforce)
+		}
+		if def.Duration < 1 {
+			def.Duration = 1
+		}
+		registry[def.ID] = def
+		order = append(order, def.ID)
+	}
+
+	sabotageRegistry = registry
+	sabotageOrder = order
+	slog.Info("Loaded sabotages", "count", len(order), "file", filename)
+	return nil
+}
+
+// SabotageDefinitions returns the registry in file order
+func SabotageDefinitions() []*SabotageDef {
+	defs := make([]*SabotageDef, 0, len(sabotageOrder))
+	for _, id := range sabotageOrder {
+		defs = append(defs, sabotageRegistry[id])
+	}
+	return defs
+}
+
+func GenerateInitialSabotageList() []*Sabotage {
+	sabotages := make([]*Sabotage, len(sabotageOrder))
+	for i, id := range sabotageOrder {
+		sabotages[i] = &Sabotage{
+			Name: id,
+			Used: false,
+		}
+	}
+	return sabotages
+}
+
+// availableSabotages returns the sabotages that can currently be used
+// against playerID
+func (room *Room) availableSabotages(playerID string) []*Sabotage {
+	var available []*Sabotage
+	for _, s := range room.AvailableSabotages[playerID] {
+		if room.Settings.EffectStacking == StackingReject && room.activeEffect(playerID, s.Name) != nil {
+			continue // Would be rejected as a duplicate anyway
+		}
+		if !s.Used || (s.ReadyRound > 0 && room.Round >= s.ReadyRound) {
+			available = append(available, s)
+		}
+	}
+	return available
+}
+
+// activeEffect returns playerID's running effect called name, if any
+func (room *Room) activeEffect(playerID string, name string) *Sabotage {
+	for _, effect := range room.PlayerEffects[playerID] {
+		if effect.Name == name && effect.RoundsLeft > 0 {
+			return effect
+		}
+	}
+	return nil
+}
+
+// ActiveEffects lists the effects playerID will suffer during the next question
+func (room *Room) ActiveEffects(playerID string) []ActiveEffect {
+	active := []ActiveEffect{}
+	for _, effect := range room.PlayerEffects[playerID] {
+		if effect.RoundsLeft <= 0 {
+			continue
+		}
+		name := effect.Name
+		if def := sabotageRegistry[effect.Name]; def != nil {
+			name = def.Name
+		}
+		active = append(active, ActiveEffect{
+			ID:         effect.Name,
+			Name:       name,
+			RoundsLeft: effect.RoundsLeft,
+			Intensity:  effect.Intensity,
+			UsedBy:     effect.UsedByID,
+		})
+	}
+	return active
+}
+
+// EnforcedEffects sums the intensity of playerID's active server-enforced
+// effects by behaviour
+func (room *Room) EnforcedEffects(playerID string) map[string]float64 {
+	enforced := make(map[string]float64)
+	for _, effect := range room.PlayerEffects[playerID] {
+		def := sabotageRegistry[effect.Name]
+		if effect.RoundsLeft > 0 && def != nil && def.Enforce != "" {
+			enforced[def.Enforce] += effect.Intensity
+		}
+	}
+	return enforced
+}
+
+// hideOptions returns a copy of options with the text of n random options
+// blanked out. At least one option always stays readable.
+func hideOptions(options []Option, n int) []Option {
+	hidden := slices.Clone(options)
+	n = min(n, len(hidden)-1)
+	for _, i := range rand.Perm(len(hidden))[:max(n, 0)] {
+		hidden[i].Text = "???"
+	}
+	return hidden
+}
+
+// TickEffects counts down every effect by one round and drops the ones
+// that have run out
+func (room *Room) TickEffects() {
+	for playerID, effects := range room.PlayerEffects {
+		remaining := []*Sabotage{}
+		for _, effect := range effects {
+			effect.RoundsLeft--
+			if effect.RoundsLeft > 0 {
+				remaining = append(remaining, effect)
+			}
+		}
+		room.PlayerEffects[playerID] = remaining
+	}
+}
+
+// consumeSabotage takes name out of playerID's pool. Sabotages with a
+// cooldown come back after that many rounds, the rest are gone for the game.
+func (room *Room) consumeSabotage(playerID string, name string) {
+	pool := room.AvailableSabotages[playerID]
+	for i, s := range pool {
+		if s.Name != name {
+			continue
+		}
+		def := sabotageRegistry[name]
+		if def == nil || def.Cooldown == 0 {
+			room.AvailableSabotages[playerID] = slices.Delete(pool, i, i+1)
+			return
+		}
+		s.Used = true
+		s.ReadyRound = room.Round + def.Cooldown + 1
+		return
+	}
+}
+
+// applySabotage spends sabotage name from target's pool and puts the effect
+// on them. usedBy is nil for sabotages assigned by the system. The target's
+// defensive item gets a chance to block or reflect it first, and if the
+// victim already suffers the same effect the room's stacking rule decides
+// the outcome. It reports whether the effect landed on target.
+func (room *Room) applySabotage(target *Client, name string, usedBy *Client) bool {
+	room.consumeSabotage(target.ID, name)
+
+	senderID := "system"
+	if usedBy != nil {
+		senderID = usedBy.ID
+	}
+	victim := room.resolveDefense(target, name, usedBy)
+	if victim == nil {
+		room.recordSabotage(name, senderID, target, nil, false)
+		return false
+	}
+	usedByID := senderID
+	if victim != target {
+		usedByID = target.ID // Reflected back to the sender
+	}
+	landed := room.landSabotage(victim, name, usedByID)
+	room.recordSabotage(name, senderID, target, victim, landed)
+	return landed && victim == target
+}
+
+// landSabotage adds effect name to victim following the room's stacking
+// rule and reports false when it was rejected as a duplicate
+func (room *Room) landSabotage(victim *Client, name string, usedByID string) bool {
+	duration, intensity := 1, 1.0
+	if def := sabotageRegistry[name]; def != nil {
+		duration, intensity = def.Duration, def.Intensity
+	}
+
+	if existing := room.activeEffect(victim.ID, name); existing != nil {
+		switch room.Settings.EffectStacking {
+		case StackingReject:
+			room.Log().Debug("Rejected duplicate sabotage", "sabotage", name, "client", victim.ID)
+			return false
+		case StackingIntensity:
+			existing.Intensity += intensity
+		}
+		existing.RoundsLeft = max(existing.RoundsLeft, duration)
+		existing.UsedByID = usedByID
+		return true
+	}
+
+	room.PlayerEffects[victim.ID] = append(room.PlayerEffects[victim.ID], &Sabotage{
+		Name:       name,
+		Used:       true,
+		TargetID:   victim.ID,
+		UsedByID:   usedByID,
+		RoundsLeft: duration,
+		Intensity:  intensity,
+	})
+	return true
+}
+
+// pickWeightedSabotage picks one of available, favouring common sabotages
+func pickWeightedSabotage(available []*Sabotage) *Sabotage {
+	total := 0
+	weights := make([]int, len(available))
+	for i, s := range available {
+		weights[i] = 1
+		if def := sabotageRegistry[s.Name]; def != nil {
+			weights[i] = rarityWeights[def.Rarity]
+		}
+		total += weights[i]
+	}
+	if total == 0 {
+		return nil
+	}
+
+	n := rand.Intn(total)
+	for i, w := range weights {
+		if n < w {
+			return available[i]
+		}
+		n -= w
+	}
+	return available[len(available)-1]
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// shuttingDown is set once a shutdown signal arrives. Guarded by clientsMutex.
+	shuttingDown bool
+	// Every open WebSocket connection, so shutdown can reach clients that
+	// aren't in a room. Guarded by clientsMutex.
+	connections = make(map[*Client]bool)
+)
+
+// shutdownServer stops new connections and rooms, tells everyone, waits up to
+// grace for running games to finish and then closes every socket with a
+// close frame. Games still running at the deadline are snapshotted so they
+// can resume after a restart.
+func shutdownServer(srv *http.Server, grace time.Duration) {
+	deadline := time.Now().Add(grace)
+
+	clientsMutex.Lock()
+	shuttingDown = true
+	for c := range connections {
+		c.Send(map[string]interface{}{
+			"type":     "server_shutdown",
+			"deadline": deadline.UnixMilli(),
+			"timeLeft": int(grace.Seconds()),
+		})
+	}
+	queueMutex.Lock()
+	matchQueue = nil
+	queueMutex.Unlock()
+	clientsMutex.Unlock()
+
+	// Stop accepting new HTTP requests and upgrades. Hijacked WebSocket
+	// connections aren't tracked by the server, so this returns quickly.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("HTTP server shutdown", "err", err)
+	}
+	cancel()
+
+	for time.Now().Before(deadline) {
+		clientsMutex.Lock()
+		playing := 0
+		for _, room := range rooms {
+			if room.Phase == PhasePlaying {
+				playing++
+			}
+		}
+		clientsMutex.Unlock()
+		if playing == 0 {
+			break
+		}
+		slog.Info("Waiting for games to finish before shutting down", "games", playing)
+		time.Sleep(time.Second)
+	}
+
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	// Games still running are saved so they can carry on after a restart,
+	// everything else is closed
+	var snapshots []RoomSnapshot
+	tokens := make(map[*Client]string)
+	for _, room := range rooms {
+		running := room.Phase == PhasePlaying || room.Phase == PhaseResuming
+		room.Close()
+		if snapshotFile == "" || !running {
+			room.abortMatch("server_shutdown")
+			room.closeReplay("server_shutdown")
+			continue
+		}
+		snapshot, seats := room.snapshot()
+		snapshots = append(snapshots, snapshot)
+		for c, token := range seats {
+			tokens[c] = token
+		}
+	}
+	if len(snapshots) > 0 {
+		saved := saveRoomSnapshot(snapshots) == nil
+		if !saved {
+			slog.Error("Running games are lost without a snapshot", "games", len(snapshots))
+		}
+		for _, snapshot := range snapshots {
+			room := rooms[snapshot.RoomCode]
+			if !saved {
+				room.abortMatch("server_shutdown")
+				room.closeReplay("server_shutdown")
+				continue
+			}
+			room.logEvent("game_suspended", map[string]int{"round": snapshot.Round})
+			room.closeReplay("")
+			for _, c := range room.Players {
+				c.Send(map[string]string{
+					"type":     "resume_token",
+					"roomCode": room.RoomCode,
+					"gameId":   room.GameID,
+					"token":    tokens[c],
+				})
+			}
+		}
+	}
+
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for c := range connections {
+		c.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		c.Conn.Close()
+	}
+	slog.Info("Shutdown complete", "connections", len(connections))
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Players of a restored game have this long to rejoin before it carries on
+// without them
+const resumeTimeout = time.Minute
+
+// File running games are saved to on shutdown and restored from at startup,
+// empty to disable
+var snapshotFile = "rooms_snapshot.json"
+
+// RoomSnapshot is a game in progress at shutdown, enough to carry on with it
+// after a restart. The question being asked and any pending sabotage or
+// reward choice are dropped, the game resumes with the next question.
+type RoomSnapshot struct {
+	RoomCode          string                 `json:"roomCode"`
+	Settings          RoomSettings           `json:"settings"`
+	Password          string                 `json:"password,omitempty"` // Left out of Settings' JSON
+	GameID            string                 `json:"gameId"`
+	StartedAt         time.Time              `json:"startedAt"`
+	Round             int                    `json:"round"`
+	SuddenDeathRounds int                    `json:"suddenDeathRounds"`
+	Players           []SnapshotPlayer       `json:"players"`
+	QuestionDeck      []int                  `json:"questionDeck"` // IDs of the questions not asked yet
+	Sabotages         map[string][]*Sabotage `json:"sabotages"`
+	Effects           map[string][]*Sabotage `json:"effects"`
+	Items             map[string][]string    `json:"items"`
+	ArmedItems        map[string]string      `json:"armedItems"`
+	Streaks           map[string]int         `json:"streaks"`
+	PowerUps          map[string][]string    `json:"powerUps"`
+	Match             *MatchRecord           `json:"match,omitempty"`
+	ReplaySeq         int                    `json:"replaySeq"`
+}
+
+// SnapshotPlayer is a seat in a saved game. The token is sent to the player
+// before the server goes down and reclaims the seat with the resume action.
+type SnapshotPlayer struct {
+	Token           string `json:"token"`
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Identified      bool   `json:"identified"`
+	Health          int    `json:"health"`
+	IsHost          bool   `json:"isHost"`
+	IsSpectator     bool   `json:"isSpectator"`
+	EliminatedRound int    `json:"eliminatedRound,omitempty"`
+}
+
+// snapshot saves the game in progress and hands every connected player a
+// new seat token. Seats nobody reclaimed since the last restart keep theirs.
+// Call with clientsMutex held.
+func (room *Room) snapshot() (RoomSnapshot, map[*Client]string) {
+	round := room.Round
+	evaluated := room.Match != nil && len(room.Match.RoundLog) > 0 &&
+		room.Match.RoundLog[len(room.Match.RoundLog)-1].Round == room.Round
+	if room.Question != nil && !evaluated {
+		round-- // Asked again from the next question
+	}
+
+	snapshot := RoomSnapshot{
+		RoomCode:          room.RoomCode,
+		Settings:          room.Settings,
+		Password:          room.Settings.Password,
+		GameID:            room.GameID,
+		StartedAt:         room.StartedAt,
+		Round:             round,
+		SuddenDeathRounds: room.SuddenDeathRounds,
+		Players:           []SnapshotPlayer{},
+		QuestionDeck:      []int{},
+		Sabotages:         room.AvailableSabotages,
+		Effects:           room.PlayerEffects,
+		Items:             room.Items,
+		ArmedItems:        room.ArmedItems,
+		Streaks:           room.Streaks,
+		PowerUps:          room.PowerUps,
+		Match:             room.Match,
+	}
+	if room.Replay != nil {
+		snapshot.ReplaySeq = room.Replay.seq
+	}
+	for _, idx := range room.QuestionDeck {
+		snapshot.QuestionDeck = append(snapshot.QuestionDeck, questions[idx].ID)
+	}
+
+	tokens := make(map[*Client]string)
+	for _, c := range room.Players {
+		tokens[c] = uuid.NewString()
+		snapshot.Players = append(snapshot.Players, SnapshotPlayer{
+			Token:           tokens[c],
+			ID:              c.ID,
+			Name:            c.Name,
+			Identified:      c.Identified,
+			Health:          c.Health,
+			IsHost:          c.IsHost,
+			IsSpectator:     c.IsSpectator,
+			EliminatedRound: c.EliminatedRound,
+		})
+	}
+	for _, seat := range room.Seats {
+		snapshot.Players = append(snapshot.Players, *seat)
+	}
+	return snapshot, tokens
+}
+
+// saveRoomSnapshot writes snapshots to snapshotFile
+func saveRoomSnapshot(snapshots []RoomSnapshot) error {
+	data, err := json.MarshalIndent(snapshots, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(snapshotFile, data, 0644); err != nil {
+		slog.Error("Error saving room snapshot", "file", snapshotFile, "err", err)
+		return err
+	}
+	slog.Info("Saved room snapshot", "rooms", len(snapshots), "file", snapshotFile)
+	return nil
+}
+
+// restoreRooms recreates the games saved at the last shutdown. They wait for
+// their players to rejoin, for up to resumeTimeout. The snapshot is removed
+// once read so a game is only restored once.
+func restoreRooms() error {
+	if snapshotFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(snapshotFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snapshots []RoomSnapshot
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		return err
+	}
+	if err := os.Remove(snapshotFile); err != nil {
+		return err
+	}
+
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	for i := range snapshots {
+		room := snapshots[i].restore()
+		roomsMutex.Lock()
+		rooms[room.RoomCode] = room
+		roomsMutex.Unlock()
+		room.Log().Info("Restored room", "round", room.Round, "seats", len(room.Seats))
+	}
+	slog.Info("Restored room snapshot", "rooms", len(snapshots), "file", snapshotFile)
+	return nil
+}
+
+// restore rebuilds the room in PhaseResuming with every seat empty
+func (s *RoomSnapshot) restore() *Room {
+	settings := s.Settings
+	settings.Password = s.Password
+
+	room := &Room{
+		RoomCode:           s.RoomCode,
+		Settings:           settings,
+		Phase:              PhaseResuming,
+		GameID:             s.GameID,
+		StartedAt:          s.StartedAt,
+		LastActivity:       time.Now(),
+		Round:              s.Round,
+		SuddenDeathRounds:  s.SuddenDeathRounds,
+		AnswerLog:          []*PlayerAnswer{},
+		AvailableSabotages: s.Sabotages,
+		PlayerEffects:      s.Effects,
+		Items:              s.Items,
+		ArmedItems:         s.ArmedItems,
+		Streaks:            s.Streaks,
+		PowerUps:           s.PowerUps,
+		Match:              s.Match,
+		Seats:              make(map[string]*SnapshotPlayer),
+	}
+	if room.AvailableSabotages == nil {
+		room.AvailableSabotages = make(map[string][]*Sabotage)
+	}
+	if room.PlayerEffects == nil {
+		room.PlayerEffects = make(map[string][]*Sabotage)
+	}
+
+	// quiz.json may have changed since, questions no longer in it are skipped
+	index := make(map[int]int)
+	for i := range questions {
+		index[questions[i].ID] = i
+	}
+	for _, id := range s.QuestionDeck {
+		if i, ok := index[id]; ok {
+			room.QuestionDeck = append(room.QuestionDeck, i)
+		}
+	}
+
+	for i := range s.Players {
+		seat := s.Players[i]
+		room.Seats[seat.Token] = &seat
+	}
+
+	room.openReplay()
+	if room.Replay != nil {
+		room.Replay.seq = s.ReplaySeq
+	}
+	room.logEvent("game_restored", map[string]int{"round": room.Round})
+
+	timer := time.AfterFunc(resumeTimeout, func() {
+		defer func() {
+			if r := recover(); r != nil {
+				slog.Error("Recovered resuming game", "room", room.RoomCode, "panic", r)
+			}
+		}()
+		clientsMutex.Lock()
+		defer clientsMutex.Unlock()
+		if room.Phase == PhaseResuming && room.GameID == s.GameID {
+			room.resumeGame()
+		}
+	})
+	room.Timers = append(room.Timers, timer)
+	return room
+}
+
+// resumeGame carries on with a restored game. Players who haven't rejoined
+// count as having left in the round the game was saved in. Call with
+// clientsMutex held.
+func (room *Room) resumeGame() {
+	for _, seat := range room.Seats {
+		room.recordLeft(&Client{ID: seat.ID, EliminatedRound: seat.EliminatedRound})
+	}
+	room.Seats = nil
+
+	if len(room.Players) == 0 {
+		room.Close()
+		room.abortMatch("not_resumed")
+		room.closeReplay("not_resumed")
+		roomsMutex.Lock()
+		delete(rooms, room.RoomCode)
+		roomsMutex.Unlock()
+		room.Log().Info("Nobody rejoined, room closed")
+		return
+	}
+
+	host := false
+	for _, c := range room.Players {
+		host = host || c.IsHost
+	}
+	if !host {
+		room.Players[0].IsHost = true
+	}
+
+	room.Phase = PhasePlaying
+	room.LastActivity = time.Now()
+	room.logEvent("game_resumed", map[string]interface{}{"players": room.PlayerInfo()})
+	room.Broadcast(map[string]interface{}{
+		"type":    "game_resumed",
+		"players": room.PlayerInfo(),
+	})
+	room.Log().Info("Game resumed", "players", len(room.Players))
+
+	room.Schedule(3*time.Second, func() {
+		if room.CheckGameOver() {
+			return
+		}
+		room.StartQuestion()
+	})
+}
+
+// handleResume puts the client back in the seat msg.Token was issued for
+// when the server shut down. The game resumes once every seat is taken.
+func handleResume(client *Client, msg Message, conn *websocket.Conn) {
+	removePlayerFromQueue(client)
+
+	var room *Room
+	var seat *SnapshotPlayer
+	for _, r := range rooms {
+		if s := r.Seats[msg.Token]; msg.Token != "" && s != nil {
+			room, seat = r, s
+		}
+	}
+	if seat == nil {
+		client.Send(map[string]string{"error": "Unknown or expired resume token"})
+		return
+	}
+	for c := range connections {
+		if c != client && c.ID == seat.ID {
+			client.Send(map[string]string{"error": "This player is already connected"})
+			return
+		}
+	}
+
+	delete(room.Seats, msg.Token)
+	client.ID = seat.ID
+	client.Name = seat.Name
+	client.Identified = seat.Identified
+	client.Health = seat.Health
+	client.IsHost = seat.IsHost
+	client.IsSpectator = seat.IsSpectator
+	client.EliminatedRound = seat.EliminatedRound
+	client.Room = room
+	room.Players = append(room.Players, client)
+	room.LastActivity = time.Now()
+	room.logEvent("rejoined", map[string]string{"playerId": client.ID, "name": client.Name})
+
+	client.Send(map[string]interface{}{
+		"type":      "resumed",
+		"roomCode":  room.RoomCode,
+		"gameId":    room.GameID,
+		"id":        client.ID,
+		"isHost":    client.IsHost,
+		"round":     room.Round,
+		"players":   room.PlayerInfo(),
+		"sabotages": SabotageDefinitions(),
+		"waiting":   len(room.Seats),
+	})
+	room.Broadcast(map[string]interface{}{
+		"type":    "player_update",
+		"players": room.PlayerInfo(),
+	})
+	client.Log().Info("Rejoined restored game", "waiting", len(room.Seats))
+
+	if len(room.Seats) == 0 {
+		room.resumeGame()
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// snapshotTestRoom saves a game between a and b that's in the middle of its
+// third question, then restores it. It returns the restored room and the
+// resume token each player was sent.
+func snapshotTestRoom(t *testing.T) (*Room, map[string]string) {
+	t.Helper()
+	oldQuestions, oldRooms, oldReplayDir, oldSnapshotFile := questions, rooms, replayDir, snapshotFile
+	t.Cleanup(func() {
+		questions, rooms, replayDir, snapshotFile = oldQuestions, oldRooms, oldReplayDir, oldSnapshotFile
+	})
+	questions = []Question{{ID: 10}, {ID: 20}, {ID: 30}}
+	rooms = make(map[string]*Room)
+	replayDir = ""
+	snapshotFile = filepath.Join(t.TempDir(), "rooms_snapshot.json")
+
+	a, _ := newTestClient(t, "a", 3, 0)
+	b, _ := newTestClient(t, "b", 0, 2)
+	a.IsHost = true
+	room := &Room{
+		RoomCode:           "SNAP",
+		Settings:           RoomSettings{Private: true, Password: "secret", TieBreak: TieBreakShared},
+		Phase:              PhasePlaying,
+		GameID:             "game-1",
+		Round:              3,
+		Players:            []*Client{a, b},
+		Question:           &questions[0],
+		QuestionDeck:       []int{2, 1},
+		AvailableSabotages: map[string][]*Sabotage{"a": {{Name: "Blur"}}, "b": {{Name: "Shake", Used: true}}},
+		PlayerEffects:      map[string][]*Sabotage{"a": {{Name: "Blur", RoundsLeft: 2}}},
+		Items:              map[string][]string{"a": {ItemShield}},
+		ArmedItems:         map[string]string{"b": ItemReflect},
+		Streaks:            map[string]int{"a": 2},
+		PowerUps:           map[string][]string{"a": {PowerUpExtraTime}},
+		Match: &MatchRecord{
+			GameResult: GameResult{GameID: "game-1"},
+			RoundLog:   []RoundRecord{{Round: 1}, {Round: 2}},
+			Roster:     []RosterPlayer{{ID: "a", Name: "a"}, {ID: "b", Name: "b"}},
+		},
+	}
+
+	clientsMutex.Lock()
+	snapshot, seats := room.snapshot()
+	clientsMutex.Unlock()
+	if err := saveRoomSnapshot([]RoomSnapshot{snapshot}); err != nil {
+		t.Fatalf("save snapshot: %v", err)
+	}
+	if err := restoreRooms(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	restored := rooms["SNAP"]
+	if restored == nil {
+		t.Fatalf("room not restored")
+	}
+	t.Cleanup(func() {
+		clientsMutex.Lock()
+		restored.StopTimers()
+		clientsMutex.Unlock()
+	})
+	return restored, map[string]string{"a": seats[a], "b": seats[b]}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	room, tokens := snapshotTestRoom(t)
+
+	if room.Phase != PhaseResuming || len(room.Seats) != 2 {
+		t.Fatalf("phase %s with %d seats, want %s with 2", room.Phase, len(room.Seats), PhaseResuming)
+	}
+	// The unanswered third question is asked again as the next one
+	if room.Round != 2 {
+		t.Errorf("round = %d, want 2", room.Round)
+	}
+	if !slices.Equal(room.QuestionDeck, []int{2, 1}) {
+		t.Errorf("deck = %v, want [2 1]", room.QuestionDeck)
+	}
+	if room.Settings.Password != "secret" || room.Settings.TieBreak != TieBreakShared {
+		t.Errorf("settings = %+v, want the password and tie-break kept", room.Settings)
+	}
+	if !room.AvailableSabotages["b"][0].Used || room.PlayerEffects["a"][0].RoundsLeft != 2 {
+		t.Errorf("sabotage pools or effects not restored")
+	}
+	if room.Items["a"][0] != ItemShield || room.ArmedItems["b"] != ItemReflect ||
+		room.Streaks["a"] != 2 || room.PowerUps["a"][0] != PowerUpExtraTime {
+		t.Errorf("items, streaks or power-ups not restored")
+	}
+	if len(room.Match.RoundLog) != 2 || len(room.Match.Roster) != 2 {
+		t.Errorf("match history not restored")
+	}
+
+	// Both players come back on new connections
+	newA, receivedA := newTestClient(t, "new-a", 5, 0)
+	newB, _ := newTestClient(t, "new-b", 5, 0)
+	clientsMutex.Lock()
+	handleResume(newA, Message{Token: tokens["a"]}, newA.Conn)
+	phase := room.Phase
+	handleResume(newB, Message{Token: tokens["b"]}, newB.Conn)
+	clientsMutex.Unlock()
+
+	if phase != PhaseResuming {
+		t.Errorf("phase after the first player rejoined = %s, want %s", phase, PhaseResuming)
+	}
+	resumed := waitFor(t, receivedA, "resumed")
+	if resumed["roomCode"] != "SNAP" || resumed["gameId"] != "game-1" {
+		t.Errorf("resumed = %v, want room SNAP and game game-1", resumed)
+	}
+	if newA.ID != "a" || newA.Health != 3 || !newA.IsHost || newA.Room != room {
+		t.Errorf("a rejoined as %s with health %d, host %t", newA.ID, newA.Health, newA.IsHost)
+	}
+	if newB.ID != "b" || !newB.IsSpectator || newB.EliminatedRound != 2 {
+		t.Errorf("b rejoined as %s, spectator %t, eliminated in %d", newB.ID, newB.IsSpectator, newB.EliminatedRound)
+	}
+	waitFor(t, receivedA, "game_resumed")
+	if room.Phase != PhasePlaying || len(room.Seats) != 0 {
+		t.Errorf("phase %s with %d seats, want %s with none", room.Phase, len(room.Seats), PhasePlaying)
+	}
+}
+
+func TestSnapshotResumeRefusals(t *testing.T) {
+	room, tokens := snapshotTestRoom(t)
+
+	client, received := newTestClient(t, "c", 5, 0)
+	clientsMutex.Lock()
+	handleResume(client, Message{Token: "not-a-token"}, client.Conn)
+	clientsMutex.Unlock()
+	if msg := <-received; msg["error"] != "Unknown or expired resume token" {
+		t.Errorf("got %v, want the unknown token error", msg)
+	}
+
+	// A token only works once
+	clientsMutex.Lock()
+	handleResume(client, Message{Token: tokens["a"]}, client.Conn)
+	other, otherReceived := newTestClient(t, "d", 5, 0)
+	handleResume(other, Message{Token: tokens["a"]}, other.Conn)
+	clientsMutex.Unlock()
+	if msg := <-otherReceived; msg["error"] != "Unknown or expired resume token" {
+		t.Errorf("got %v, want the unknown token error", msg)
+	}
+
+	// Nobody else comes back, so the game goes on without b
+	clientsMutex.Lock()
+	room.resumeGame()
+	clientsMutex.Unlock()
+	if room.Phase != PhasePlaying || len(room.Players) != 1 {
+		t.Errorf("phase %s with %d players, want %s with 1", room.Phase, len(room.Players), PhasePlaying)
+	}
+	if b := room.Match.Roster[1]; b.LeftRound != 2 {
+		t.Errorf("b left in round %d, want 2", b.LeftRound)
+	}
+}
+
+func TestSnapshotNobodyRejoins(t *testing.T) {
+	room, _ := snapshotTestRoom(t)
+
+	clientsMutex.Lock()
+	room.resumeGame()
+	clientsMutex.Unlock()
+
+	if rooms["SNAP"] != nil {
+		t.Errorf("room still open with nobody back")
+	}
+	if room.Match != nil {
+		t.Errorf("match not stored")
+	}
+}
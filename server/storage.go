@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+var errMatchNotFound = errors.New("match not found")
+
+// MatchStore keeps the history of games. Games in progress are saved after
+// every round and have a zero EndedAt until they end.
+type MatchStore interface {
+	SaveMatch(match *MatchRecord) error
+	Match(gameID string) (*MatchRecord, error)
+	// Matches returns every game that ended at or after since, oldest first
+	Matches(since time.Time) ([]*MatchRecord, error)
+	// Unfinished returns the games that haven't ended
+	Unfinished() ([]*MatchRecord, error)
+	Close() error
+}
+
+// store is nil when match history is disabled
+var store MatchStore
+
+var (
+	// Matches waiting to be written by the match writer. Guarded by
+	// clientsMutex, nil when the writer isn't running.
+	matchWrites     chan *MatchRecord
+	matchWriterDone chan struct{}
+)
+
+// MatchRecord is the full history of one game. The embedded GameResult holds
+// the final placements, the rest is filled in as the game is played.
+type MatchRecord struct {
+	GameResult
+	RoundLog  []RoundRecord    `json:"roundLog"`
+	Sabotages []SabotageRecord `json:"sabotages"`
+	// Roster is everyone who started the game, so players who leave are
+	// still placed
+	Roster []RosterPlayer `json:"roster"`
+	// Aborted is why the game stopped before it had a winner, empty if it
+	// finished. Placements of aborted games are where players stood then.
+	Aborted string `json:"aborted,omitempty"`
+}
+
+type RosterPlayer struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Round the player left the game in, or was eliminated in if that came
+	// first. Only meaningful once they're no longer in the room.
+	LeftRound int `json:"leftRound,omitempty"`
+}
+
+type RoundRecord struct {
+	Round         int            `json:"round"`
+	QuestionID    int            `json:"questionId"`
+	Question      string         `json:"question"`
+	CorrectAnswer string         `json:"correctAnswer"`
+	Answers       []AnswerRecord `json:"answers"`
+	WinnerID      string         `json:"winnerId,omitempty"`
+	LoserIDs      []string       `json:"loserIds"`
+	Health        map[string]int `json:"health"` // Health of each player after the round
+}
+
+type AnswerRecord struct {
+	PlayerID string `json:"playerId"`
+	OptionID string `json:"optionId,omitempty"` // Empty if the player didn't answer
+	Answer   string `json:"answer,omitempty"`
+	Correct  bool   `json:"correct"`
+	TimeMs   int64  `json:"timeMs,omitempty"`
+}
+
+type SabotageRecord struct {
+	Round    int    `json:"round"`
+	Name     string `json:"name"`
+	UsedByID string `json:"usedById"` // "system" for random sabotages
+	TargetID string `json:"targetId"`
+	VictimID string `json:"victimId,omitempty"` // Who it landed on, the sender if reflected
+	Landed   bool   `json:"landed"`
+}
+
+// recordRound adds the round that was just evaluated to the match history
+// and the replay
+func (room *Room) recordRound(question *Question, result *RoundResult) {
+	optionText := make(map[string]string)
+	for _, o := range question.Options {
+		optionText[o.ID] = o.Text
+	}
+
+	record := RoundRecord{
+		Round:         room.Round,
+		QuestionID:    question.ID,
+		Question:      question.Text,
+		CorrectAnswer: optionText[question.Answer],
+		Answers:       []AnswerRecord{},
+		LoserIDs:      []string{},
+		Health:        make(map[string]int),
+	}
+	for _, pa := range room.AnswerLog {
+		answer := AnswerRecord{
+			PlayerID: pa.Client.ID,
+			OptionID: pa.Answer,
+			Answer:   optionText[pa.Answer],
+			Correct:  pa.Correct,
+		}
+		if pa.Answer != "" {
+			answer.TimeMs = pa.AnswerTime
+		}
+		record.Answers = append(record.Answers, answer)
+	}
+	if result.Winner != nil && result.Winner.Client != nil {
+		record.WinnerID = result.Winner.Client.ID
+	}
+	for _, l := range result.Losers {
+		if l != nil && l.Client != nil {
+			record.LoserIDs = append(record.LoserIDs, l.Client.ID)
+		}
+	}
+	for _, c := range room.Players {
+		record.Health[c.ID] = c.Health
+	}
+	room.logEvent("round_result", record)
+	if room.Match != nil {
+		room.Match.RoundLog = append(room.Match.RoundLog, record)
+		saveMatch(room.Match)
+	}
+}
+
+func (room *Room) recordSabotage(name string, usedByID string, target *Client, victim *Client, landed bool) {
+	record := SabotageRecord{
+		Round:    room.Round,
+		Name:     name,
+		UsedByID: usedByID,
+		TargetID: target.ID,
+		Landed:   landed,
+	}
+	if victim != nil {
+		record.VictimID = victim.ID
+	}
+	room.logEvent("sabotage", record)
+	if landed {
+		sabotagesApplied.WithLabelValues(name).Inc()
+	}
+	if room.Match == nil {
+		return
+	}
+	room.Match.Sabotages = append(room.Match.Sabotages, record)
+}
+
+// abortMatch stores what was played of a game that stopped early. Call with
+// clientsMutex held.
+func (room *Room) abortMatch(reason string) {
+	if room.Match == nil {
+		return
+	}
+	room.Match.EndedAt = time.Now()
+	room.Match.Rounds = room.Round
+	room.Match.Players = resultPlayers(computePlacements(room.standings(), room.Round))
+	room.Match.Aborted = reason
+	saveMatch(room.Match)
+	room.Match = nil
+	room.Log().Info("Saved unfinished match", "reason", reason, "rounds", room.Round)
+}
+
+// standings returns everyone who started the game in progress: players still
+// in the room as they are, and those who left as eliminated in the round
+// they left
+func (room *Room) standings() []*Client {
+	if room.Match == nil {
+		return room.Players
+	}
+	inRoom := make(map[string]*Client)
+	for _, c := range room.Players {
+		inRoom[c.ID] = c
+	}
+	players := []*Client{}
+	for _, p := range room.Match.Roster {
+		if c := inRoom[p.ID]; c != nil {
+			players = append(players, c)
+			continue
+		}
+		players = append(players, &Client{ID: p.ID, Name: p.Name, EliminatedRound: p.LeftRound})
+	}
+	return players
+}
+
+// recordLeft notes the round a player left the game in progress
+func (room *Room) recordLeft(client *Client) {
+	if room.Match == nil {
+		return
+	}
+	for i := range room.Match.Roster {
+		p := &room.Match.Roster[i]
+		if p.ID != client.ID {
+			continue
+		}
+		p.LeftRound = room.Round
+		if client.EliminatedRound > 0 {
+			p.LeftRound = client.EliminatedRound
+		}
+	}
+}
+
+// saveMatch queues a copy of match for the match writer, so the game can
+// carry on changing it. Call with clientsMutex held.
+func saveMatch(match *MatchRecord) {
+	if store == nil {
+		return
+	}
+	if matchWrites == nil {
+		slog.Warn("Match writer stopped, match not stored", "game", match.GameID)
+		return
+	}
+	saved := *match
+	saved.RoundLog = slices.Clone(match.RoundLog)
+	saved.Sabotages = slices.Clone(match.Sabotages)
+	saved.Roster = slices.Clone(match.Roster)
+	matchWrites <- &saved
+}
+
+// startMatchWriter writes queued matches to the store in the background, so
+// disk syncs don't hold up every room
+func startMatchWriter() {
+	matchWrites = make(chan *MatchRecord, 256)
+	matchWriterDone = make(chan struct{})
+	go func(s MatchStore, writes <-chan *MatchRecord, done chan<- struct{}) {
+		defer close(done)
+		for match := range writes {
+			if err := s.SaveMatch(match); err != nil {
+				slog.Error("Error storing match", "game", match.GameID, "err", err)
+			}
+		}
+	}(store, matchWrites, matchWriterDone)
+}
+
+// stopMatchWriter waits for queued matches to be written. Matches saved after
+// it returns are dropped.
+func stopMatchWriter() {
+	clientsMutex.Lock()
+	writes := matchWrites
+	matchWrites = nil
+	clientsMutex.Unlock()
+	if writes == nil {
+		return
+	}
+	close(writes)
+	<-matchWriterDone
+}
+
+// closeUnfinishedMatches ends the games the server went down in the middle
+// of without a snapshot, as aborted where players stood after the last
+// round. Call after restoreRooms, whose games carry on.
+func closeUnfinishedMatches() error {
+	if store == nil {
+		return nil
+	}
+	matches, err := store.Unfinished()
+	if err != nil {
+		return err
+	}
+	running := make(map[string]bool)
+	for _, room := range rooms {
+		running[room.GameID] = true
+	}
+	closed := 0
+	for _, match := range matches {
+		if running[match.GameID] {
+			continue
+		}
+		match.EndedAt = time.Now()
+		match.Rounds = len(match.RoundLog)
+		match.Players = resultPlayers(computePlacements(match.lastStandings(), match.Rounds))
+		match.Aborted = "server_restart"
+		if err := store.SaveMatch(match); err != nil {
+			return err
+		}
+		closed++
+	}
+	if closed > 0 {
+		slog.Info("Closed unfinished matches", "matches", closed)
+	}
+	return nil
+}
+
+// lastStandings rebuilds the players of a stored match as they were after
+// its last round, for placing them
+func (match *MatchRecord) lastStandings() []*Client {
+	players := []*Client{}
+	for _, p := range match.Roster {
+		c := &Client{ID: p.ID, Name: p.Name, EliminatedRound: p.LeftRound}
+		if p.LeftRound == 0 && len(match.RoundLog) > 0 {
+			c.Health = match.RoundLog[len(match.RoundLog)-1].Health[p.ID]
+			for _, round := range match.RoundLog {
+				if health, ok := round.Health[p.ID]; ok && health == 0 {
+					c.EliminatedRound = round.Round
+					break
+				}
+			}
+		}
+		players = append(players, c)
+	}
+	return players
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAbortMatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		abort func(t *testing.T, room *Room)
+		// Placements by player ID
+		want map[string]int
+	}{
+		{
+			name: "aborted with players in the room",
+			abort: func(t *testing.T, room *Room) {
+				room.abortMatch("idle")
+			},
+			want: map[string]int{"a": 1, "c": 2, "b": 3},
+		},
+		{
+			name: "last player leaves",
+			abort: func(t *testing.T, room *Room) {
+				clientsMutex.Lock()
+				defer clientsMutex.Unlock()
+				for _, c := range room.Players {
+					c.Room = room
+				}
+				// b was eliminated in round 1 and c in round 2, so a is the
+				// last one left in round 3
+				room.Players = room.Players[:1]
+				room.Match.Roster[1].LeftRound = 1
+				room.Match.Roster[2].LeftRound = 2
+				room.Round = 3
+				removeClientFromRoom(room.Players[0])
+			},
+			want: map[string]int{"a": 1, "c": 2, "b": 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := openBoltStore(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatalf("open store: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			store = db
+			t.Cleanup(func() { store = nil })
+			startMatchWriter()
+
+			a, _ := newTestClient(t, "a", 2, 0)
+			room := &Room{
+				RoomCode: "TEST",
+				GameID:   "game-1",
+				Phase:    PhasePlaying,
+				Round:    2,
+				Players: []*Client{
+					a,
+					{ID: "b", Name: "b", Health: 0, EliminatedRound: 1},
+					{ID: "c", Name: "c", Health: 0, EliminatedRound: 2},
+				},
+				Match: &MatchRecord{
+					GameResult: GameResult{GameID: "game-1", RoomCode: "TEST", StartedAt: time.Now()},
+					RoundLog:   []RoundRecord{{Round: 1}, {Round: 2}},
+					Roster:     []RosterPlayer{{ID: "a", Name: "a"}, {ID: "b", Name: "b"}, {ID: "c", Name: "c"}},
+				},
+			}
+			rounds := room.Round
+
+			tt.abort(t, room)
+			stopMatchWriter()
+
+			if room.Match != nil {
+				t.Errorf("room still has a match after aborting")
+			}
+			match, err := store.Match("game-1")
+			if err != nil {
+				t.Fatalf("load match: %v", err)
+			}
+			if match.Aborted == "" || match.EndedAt.IsZero() || len(match.RoundLog) != 2 {
+				t.Errorf("stored match aborted %q at %v with %d rounds logged, want a reason, an end time and 2",
+					match.Aborted, match.EndedAt, len(match.RoundLog))
+			}
+			if match.Rounds < rounds {
+				t.Errorf("rounds = %d, want at least %d", match.Rounds, rounds)
+			}
+			if len(match.Players) != len(tt.want) {
+				t.Fatalf("players = %+v, want %d", match.Players, len(tt.want))
+			}
+			for _, p := range match.Players {
+				if p.Place != tt.want[p.ID] {
+					t.Errorf("%s placed %d, want %d", p.ID, p.Place, tt.want[p.ID])
+				}
+			}
+
+			// Nothing more is stored once the match is gone
+			room.abortMatch("idle")
+			if matches, _ := store.Matches(time.Time{}); len(matches) != 1 {
+				t.Errorf("got %d matches, want 1", len(matches))
+			}
+		})
+	}
+}
+
+func TestCloseUnfinishedMatches(t *testing.T) {
+	db, err := openBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	store = db
+	t.Cleanup(func() { store = nil })
+	startMatchWriter()
+
+	// a outlasts b, c left in round 1 and the server dies after round 2
+	a := &Client{ID: "a", Name: "a", Health: 3}
+	b := &Client{ID: "b", Name: "b", Health: 1}
+	room := &Room{
+		RoomCode: "TEST",
+		Players:  []*Client{a, b},
+		Match: &MatchRecord{
+			GameResult: GameResult{GameID: "game-1", StartedAt: time.Now()},
+			Roster:     []RosterPlayer{{ID: "a", Name: "a"}, {ID: "b", Name: "b"}, {ID: "c", Name: "c", LeftRound: 1}},
+		},
+	}
+	question := &Question{ID: 1, Options: []Option{{ID: "x", Text: "x"}}, Answer: "x"}
+	clientsMutex.Lock()
+	room.Round = 1
+	room.recordRound(question, &RoundResult{})
+	b.Health = 0
+	room.Round = 2
+	room.recordRound(question, &RoundResult{})
+	clientsMutex.Unlock()
+	stopMatchWriter()
+
+	// Each round is stored as it's played, but games in progress aren't
+	// part of the history yet
+	unfinished, err := store.Unfinished()
+	if err != nil || len(unfinished) != 1 || len(unfinished[0].RoundLog) != 2 {
+		t.Fatalf("unfinished = %v, %v, want one game with 2 rounds", unfinished, err)
+	}
+	if matches, _ := store.Matches(time.Time{}); len(matches) != 0 {
+		t.Errorf("got %d matches in the history, want 0", len(matches))
+	}
+
+	if err := closeUnfinishedMatches(); err != nil {
+		t.Fatalf("close unfinished: %v", err)
+	}
+	match, err := store.Match("game-1")
+	if err != nil {
+		t.Fatalf("load match: %v", err)
+	}
+	if match.Aborted != "server_restart" || match.EndedAt.IsZero() || match.Rounds != 2 {
+		t.Errorf("match aborted %q at %v after %d rounds, want server_restart, an end time and 2",
+			match.Aborted, match.EndedAt, match.Rounds)
+	}
+	want := map[string]int{"a": 1, "b": 2, "c": 3}
+	for _, p := range match.Players {
+		if p.Place != want[p.ID] {
+			t.Errorf("%s placed %d, want %d", p.ID, p.Place, want[p.ID])
+		}
+	}
+	if len(match.Players) != len(want) {
+		t.Errorf("players = %+v, want %d", match.Players, len(want))
+	}
+}
@@ -1,11 +1,12 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"math/rand"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
@@ -23,6 +24,18 @@ func generateClientID() string {
 	return id.String()
 }
 
+// generateOptionID returns a random ID for one player's view of an option
+func generateOptionID() string {
+	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
+	const length = 6
+
+	var id strings.Builder
+	for i := 0; i < length; i++ {
+		id.WriteByte(charset[rand.Intn(len(charset))])
+	}
+	return id.String()
+}
+
 func generateRoomCode() string {
 	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	const length = 4
@@ -45,20 +58,22 @@ func addToMatchQueue(client *Client) {
 	// Remove any dead clients
 	activeQueue := []*Client{}
 	for _, c := range matchQueue {
-		if c.Conn != nil && c.Conn.WriteMessage(websocket.PingMessage, nil) == nil {
+		if c.Conn != nil && c.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)) == nil {
 			activeQueue = append(activeQueue, c)
 		} else {
-			log.Printf("Dropped inactive client: %s\n", c.Name)
+			c.Log().Info("Dropped inactive client from match queue")
+			droppedClients.WithLabelValues("queue_ping").Inc()
 		}
 	}
 	matchQueue = activeQueue
 
+	client.QueuedAt = time.Now()
 	matchQueue = append(matchQueue, client)
-	log.Printf("Added %s to match queue. Queue length: %d\n", client.Name, len(matchQueue))
+	client.Log().Info("Added to match queue", "queueLength", len(matchQueue))
 
 	limit := 2
 
-	log.Printf("Current match queue: %v\n", func() []string {
+	slog.Debug("Current match queue", "players", func() []string {
 		names := []string{}
 		for _, c := range matchQueue {
 			names = append(names, c.Name)
@@ -66,11 +81,11 @@ func addToMatchQueue(client *Client) {
 		return names
 	}())
 
-	err := client.Conn.WriteJSON(map[string]interface{}{
+	err := client.Send(map[string]interface{}{
 		"type": "searching",
 	})
 	if err != nil {
-		log.Printf("Error sending searching message to %s: %v\n", client.Name, err)
+		client.Log().Warn("Error sending searching message", "err", err)
 	}
 
 	if len(matchQueue) >= limit {
@@ -81,17 +96,23 @@ func addToMatchQueue(client *Client) {
 		roomCode := generateRoomCode()
 
 		newRoom := &Room{
-			Players:           matched,
-			RoomCode:          roomCode,
-			Question:          nil,
-			SabotageSelection: nil,
-			AnswerLog:         []*PlayerAnswer{},
-			AvailableSabotages: map[string][]*Sabotage{
-				client.ID: GenerateInitialSabotageList(),
-			},
-			PlayerEffects: map[string][]*Sabotage{
-				client.ID: {},
-			},
+			Players:            matched,
+			RoomCode:           roomCode,
+			Settings:           defaultRoomSettings(),
+			Phase:              PhaseLobby,
+			LastActivity:       time.Now(),
+			Question:           nil,
+			SabotageSelection:  nil,
+			AnswerLog:          []*PlayerAnswer{},
+			AvailableSabotages: map[string][]*Sabotage{},
+			PlayerEffects:      map[string][]*Sabotage{},
+		}
+		// Matchmade rooms never show up in the browser
+		newRoom.Settings.Private = true
+		// Every matched player gets their own sabotage pool
+		for _, c := range matched {
+			newRoom.AvailableSabotages[c.ID] = GenerateInitialSabotageList()
+			newRoom.PlayerEffects[c.ID] = []*Sabotage{}
 		}
 
 		roomsMutex.Lock()
@@ -108,13 +129,14 @@ func addToMatchQueue(client *Client) {
 		// clientsPerRoom[roomCode] = matched
 		// // clientsMutex.Unlock()
 		// clientsPerRoom[roomCode] = matched
-		log.Printf("Match found for %d players in room %s\n", len(matched), roomCode)
+		slog.Info("Match found", "room", roomCode, "players", len(matched))
 
 		for i, c := range matched {
+			observeSince(queueWaitSeconds, c.QueuedAt)
 			c.Room = newRoom
 			c.IsHost = (i == 0) // First player is host
 
-			err := c.Conn.WriteJSON(map[string]interface{}{
+			err := c.Send(map[string]interface{}{
 				"type":        "match_found",
 				"roomCode":    roomCode,
 				"isHost":      c.IsHost,
@@ -122,7 +144,7 @@ func addToMatchQueue(client *Client) {
 				"id":          c.ID,
 			})
 			if err != nil {
-				log.Printf("Error sending match_found to %s: %v\n", c.Name, err)
+				c.Log().Warn("Error sending match_found", "err", err)
 			}
 			broadcastPlayerCount(newRoom)
 		}
@@ -131,16 +153,21 @@ func addToMatchQueue(client *Client) {
 		go func() {
 			defer func() {
 				if r := recover(); r != nil {
-					log.Printf("Recovered in goroutine: %v", r)
+					slog.Error("Recovered starting matched game", "room", roomCode, "panic", r)
 				}
 			}()
 			time.Sleep(5 * time.Second)
 			clientsMutex.Lock()
 			defer clientsMutex.Unlock()
 
-			// Check if room still exists before starting game
+			// Check the room still has enough players before starting game
 			roomClients := newRoom.Players
-			if len(roomClients) > 0 {
+			if shuttingDown {
+				newRoom.Log().Info("Server is shutting down, not starting matched game")
+				for _, c := range roomClients {
+					c.Send(map[string]string{"error": "Server is shutting down, the game won't start"})
+				}
+			} else if len(roomClients) >= 2 {
 				var host *Client
 				for _, c := range roomClients {
 					if c.IsHost {
@@ -150,10 +177,13 @@ func addToMatchQueue(client *Client) {
 				}
 				if host != nil {
 					startGame(newRoom) // Pass the host client to startGame
-					log.Printf("Game started in room %s with %d players\n", roomCode, len(roomClients))
+					newRoom.Log().Info("Matched game started", "players", len(roomClients))
 				}
 			} else {
-				log.Printf("Room %s no longer exists, cannot start game\n", roomCode)
+				newRoom.Log().Info("Matched room lost its opponents, not starting game", "players", len(roomClients))
+				for _, c := range roomClients {
+					c.Send(map[string]string{"error": "Need at least 2 players to start"})
+				}
 			}
 		}()
 	}
@@ -210,23 +240,51 @@ func addToMatchQueue(client *Client) {
 func removeClientFromRoom(client *Client) {
 	room := client.Room
 	if room == nil {
-		log.Printf("removeClientFromRoom: client %s is not in any room\n", client.Name)
+		client.Log().Warn("removeClientFromRoom: client is not in any room")
 		return
 	}
 
 	// Remove client from room first
+	var remainingSpectators []*Client
+	for _, c := range room.Watchers {
+		if c != client {
+			remainingSpectators = append(remainingSpectators, c)
+		}
+	}
+	room.Watchers = remainingSpectators
+
 	var remainingClients []*Client
 	for _, c := range room.Players {
 		if c != client {
 			remainingClients = append(remainingClients, c)
+		} else {
+			room.recordLeft(client)
 		}
 	}
 	room.Players = remainingClients
+	room.logEvent("left", map[string]string{"playerId": client.ID, "name": client.Name})
 
-	// If room is empty, delete it
+	// A restored game keeps its room until the other players rejoin or the
+	// resume deadline passes
+	if len(remainingClients) == 0 && len(room.Seats) > 0 {
+		room.Log().Info("Room empty, waiting for players to rejoin", "seats", len(room.Seats))
+		return
+	}
+
+	// If no players are left, delete the room and send any spectators back
 	if len(remainingClients) == 0 {
+		for _, c := range remainingSpectators {
+			c.Room = nil
+			c.IsSpectator = false
+			c.Send(map[string]string{"type": "left_room"})
+		}
+		room.Close()
+		room.abortMatch("room_empty")
+		room.closeReplay("room_empty")
+		roomsMutex.Lock()
 		delete(rooms, room.RoomCode)
-		log.Printf("Room %s deleted (empty)\n", room.RoomCode)
+		roomsMutex.Unlock()
+		room.Log().Info("Room deleted (empty)")
 		return
 	}
 
@@ -234,11 +292,11 @@ func removeClientFromRoom(client *Client) {
 	if client.IsHost {
 		// Assign the next player as host
 		remainingClients[0].IsHost = true
-		log.Printf("New host assigned in room %s: %s\n", room.RoomCode, remainingClients[0].Name)
+		room.Log().Info("New host assigned", "client", remainingClients[0].ID)
 
 		// Notify all remaining players about the host change
 		for _, c := range remainingClients {
-			c.Conn.WriteJSON(map[string]interface{}{
+			c.Send(map[string]interface{}{
 				"type":     "host_changed",
 				"isHost":   c == remainingClients[0],
 				"roomCode": room.RoomCode,
@@ -250,6 +308,16 @@ func removeClientFromRoom(client *Client) {
 
 	// Broadcast updated player count to remaining players
 	broadcastPlayerCount(room)
+
+	// A game can't go on without an opponent, so the last player standing
+	// wins rather than waiting on timers that only they can answer
+	if room.Phase == PhasePlaying && len(room.ActivePlayers()) <= 1 {
+		room.CheckGameOver()
+	}
+	// Everyone left may already have voted for a rematch
+	if room.RematchVotes != nil {
+		room.CheckRematch()
+	}
 }
 
 func removePlayerFromQueue(client *Client) {
@@ -257,7 +325,7 @@ func removePlayerFromQueue(client *Client) {
 	for i, queuedClient := range matchQueue {
 		if queuedClient == client {
 			matchQueue = append(matchQueue[:i], matchQueue[i+1:]...)
-			log.Printf("Removed %s from match queue (switching to create/join)\n", client.Name)
+			client.Log().Info("Removed from match queue (switching to create/join)")
 			break
 		}
 	}
@@ -270,16 +338,25 @@ func broadcastPlayerCount(room *Room) {
 		playerNames = append(playerNames, c.Name)
 	}
 
-	for _, c := range room.Players {
-		c.Conn.WriteJSON(map[string]interface{}{
-			"type":        "waiting",
-			"playerCount": len(room.Players),
-			"players":     playerNames,
-			"id":          c.ID,
+	for _, c := range room.Members() {
+		c.Send(map[string]interface{}{
+			"type":           "waiting",
+			"playerCount":    len(room.Players),
+			"players":        playerNames,
+			"spectatorCount": len(room.Spectators()),
+			"id":             c.ID,
 		})
 	}
 }
 
+func defaultRoomSettings() RoomSettings {
+	return RoomSettings{
+		TieBreak:       TieBreakSuddenDeath,
+		EffectStacking: StackingRefresh,
+		Rewards:        []string{RewardSabotage},
+	}
+}
+
 func startGame(room *Room) {
 	// clientsMutex.Lock()
 	// defer clientsMutex.Unlock()
@@ -289,10 +366,39 @@ func startGame(room *Room) {
 	// 	return
 	// }
 	if room == nil {
-		log.Println("startGame: room is nil")
+		slog.Error("startGame: room is nil")
 		return
 	}
 
+	room.Phase = PhasePlaying
+	room.GameID = uuid.NewString()
+	room.StartedAt = time.Now()
+	gamesStarted.Inc()
+	room.SuddenDeathRounds = 0
+	room.Items = make(map[string][]string)
+	room.ArmedItems = make(map[string]string)
+	room.Streaks = make(map[string]int)
+	room.PowerUps = make(map[string][]string)
+	room.Match = &MatchRecord{
+		GameResult: GameResult{
+			GameID:    room.GameID,
+			RoomCode:  room.RoomCode,
+			StartedAt: room.StartedAt,
+		},
+		RoundLog:  []RoundRecord{},
+		Sabotages: []SabotageRecord{},
+		Roster:    []RosterPlayer{},
+	}
+	for _, c := range room.Players {
+		room.Match.Roster = append(room.Match.Roster, RosterPlayer{ID: c.ID, Name: c.Name})
+	}
+	room.openReplay()
+	room.logEvent("game_started", map[string]interface{}{
+		"roomCode": room.RoomCode,
+		"players":  room.PlayerInfo(),
+		"settings": room.Settings,
+	})
+
 	players := []map[string]interface{}{}
 	for _, c := range room.Players {
 		players = append(players, map[string]interface{}{
@@ -302,15 +408,82 @@ func startGame(room *Room) {
 			"health": c.Health,
 		})
 	}
-	for _, c := range room.Players {
-		c.Conn.WriteJSON(map[string]interface{}{
-			"type":     "start",
-			"players":  players,
-			"roomCode": room.RoomCode,
+	room.Broadcast(map[string]interface{}{
+		"type":      "start",
+		"players":   players,
+		"roomCode":  room.RoomCode,
+		"gameId":    room.GameID,
+		"sabotages": SabotageDefinitions(),
+	})
+	room.Log().Info("Game started", "players", len(room.Players))
+
+	room.Schedule(2*time.Second, room.StartQuestion)
+}
+
+// listPublicRooms returns the public rooms that are still waiting in the lobby.
+// Callers must hold clientsMutex so player lists don't change underneath us.
+func listPublicRooms() []map[string]interface{} {
+	roomsMutex.RLock()
+	defer roomsMutex.RUnlock()
+
+	list := []map[string]interface{}{}
+	for code, room := range rooms {
+		if room.Settings.Private || room.Phase != PhaseLobby {
+			continue
+		}
+		host := ""
+		for _, c := range room.Players {
+			if c.IsHost {
+				host = c.Name
+				break
+			}
+		}
+		list = append(list, map[string]interface{}{
+			"roomCode":    code,
+			"host":        host,
+			"playerCount": len(room.Players),
+			"maxPlayers":  maxPlayers,
+			"settings":    room.Settings,
 		})
 	}
-	log.Printf("Game started in room %v\n", room)
+	return list
+}
 
-	time.Sleep(2 * time.Second)
-	room.StartQuestion()
+// startRoomJanitor periodically closes rooms that are empty or have seen no
+// activity for longer than idleTimeout
+func startRoomJanitor(idleTimeout time.Duration) {
+	interval := idleTimeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	go func() {
+		for range time.Tick(interval) {
+			clientsMutex.Lock()
+			roomsMutex.Lock()
+			for code, room := range rooms {
+				if len(room.Players) > 0 && time.Since(room.LastActivity) < idleTimeout {
+					continue
+				}
+				if room.Phase == PhaseResuming {
+					continue // Closed by its own resume deadline
+				}
+				room.Close()
+				room.abortMatch("idle")
+				room.closeReplay("idle")
+				for _, c := range room.Members() {
+					c.Room = nil
+					c.IsHost = false
+					c.IsSpectator = false
+					c.Send(map[string]string{
+						"type":   "room_closed",
+						"reason": "idle",
+					})
+				}
+				delete(rooms, code)
+				room.Log().Info("Room closed after being idle", "idleSince", room.LastActivity.Format(time.RFC3339))
+			}
+			roomsMutex.Unlock()
+			clientsMutex.Unlock()
+		}
+	}()
 }